@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 回测K线周期与单次下载数量
+const (
+	backtestInterval     = "5m"
+	backtestKlineLimit   = 1500
+	backtestWarmupKlines = 210
+)
+
+// 按信号类型统计
+type signalStats struct {
+	Trades  int     `json:"trades"`
+	Wins    int     `json:"wins"`
+	PnL     float64 `json:"pnl"`
+	WinRate float64 `json:"winRate"`
+}
+
+// 回测结果
+type backtestSummary struct {
+	Start          time.Time               `json:"start"`
+	End            time.Time               `json:"end"`
+	Cycles         int                     `json:"cycles"`
+	InitialBalance float64                 `json:"initialBalance"`
+	FinalEquity    float64                 `json:"finalEquity"`
+	Return         float64                 `json:"return"`
+	MaxDrawdown    float64                 `json:"maxDrawdown"`
+	Trades         int                     `json:"trades"`
+	WinRate        float64                 `json:"winRate"`
+	Fees           float64                 `json:"fees"`
+	Funding        float64                 `json:"funding"`
+	Signals        map[string]*signalStats `json:"signals"`
+}
+
+// 权益曲线点
+type equityPoint struct {
+	Time   time.Time
+	Equity float64
+}
+
+// backtest 命令 回放快照资金流与历史K线 走与实盘相同的下单流程
+func runBacktest(args []string) error {
+	flags := flag.NewFlagSet("backtest", flag.ExitOnError)
+	recordsDir := flags.String("records", config.ReplayDir, "快照目录")
+	dataDir := flags.String("data", "backtest_data", "历史K线与交易信息缓存目录")
+	outDir := flags.String("out", "backtest_out", "结果输出目录")
+	balance := flags.Float64("balance", 1000, "初始余额 USDT")
+	from := flags.String("from", "", "回测开始时间 2006-01-02T15:04:05Z07:00 为空从第一个快照开始")
+	to := flags.String("to", "", "回测结束时间 为空到最后一个快照")
+	params := flags.String("params", "", "覆盖配置的 JSON 例如 {\"rsiLength\":14}")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *params != "" {
+		if err := json.Unmarshal([]byte(*params), &config); err != nil {
+			return fmt.Errorf("-params: %w", err)
+		}
+	}
+	var window backtestWindow
+	var err error
+	if *from != "" {
+		if window.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return fmt.Errorf("-from: %w", err)
+		}
+	}
+	if *to != "" {
+		if window.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return fmt.Errorf("-to: %w", err)
+		}
+	}
+	summary, err := backtest(*recordsDir, *dataDir, *outDir, *balance, window)
+	if err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(summary, "", "  ")
+	fmt.Println(string(b))
+	return nil
+}
+
+// 回测时间范围 为零值时不限制
+type backtestWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+func (w backtestWindow) contains(t time.Time) bool {
+	return (w.From.IsZero() || !t.Before(w.From)) && (w.To.IsZero() || t.Before(w.To))
+}
+
+// 两端各放宽 d
+func (w backtestWindow) widen(d time.Duration) backtestWindow {
+	if !w.From.IsZero() {
+		w.From = w.From.Add(-d)
+	}
+	if !w.To.IsZero() {
+		w.To = w.To.Add(d)
+	}
+	return w
+}
+
+func backtest(recordsDir, dataDir, outDir string, balance float64, window backtestWindow) (*backtestSummary, error) {
+	// 预热覆盖信号用的 5m K线和 ATR 周期的K线
+	warmup := backtestWarmupKlines * 5 * time.Minute
+	atrLength, atrInterval := atrSettings()
+	atrPeriod, err := simKlineInterval(atrInterval)
+	if err != nil {
+		return nil, fmt.Errorf("atrInterval: %w", err)
+	}
+	if atrWarmup := time.Duration(atrLength*3+2) * atrPeriod; atrWarmup > warmup {
+		warmup = atrWarmup
+	}
+
+	paths, err := listCycleRecords(recordsDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s 中没有快照", recordsDir)
+	}
+	// 历史数据按全部快照的范围下载 不同时间窗口共用缓存
+	first, err := readCycleRecord(paths[0])
+	if err != nil {
+		return nil, err
+	}
+	last, err := readCycleRecord(paths[len(paths)-1])
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// 历史数据从实盘接口下载
+	market := client
+	info, err := loadExchangeInfo(market, filepath.Join(dataDir, "exchangeInfo.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := setupSymbols(info); err != nil {
+		return nil, err
+	}
+	start := first.Time.Add(-warmup)
+	end := last.Time.Add(5 * time.Minute)
+	sim := newSimExchange(info, balance, func(symbol string) ([]*futures.Kline, error) {
+		return loadHistoryKlines(market, dataDir, symbol, start, end)
+	})
+	sim.loadFunding = func(symbol string) ([]*futures.FundingRate, error) {
+		return loadFundingRates(market, dataDir, symbol, start, end)
+	}
+	sim.Advance(first.Time)
+	if !window.From.IsZero() && window.From.After(first.Time) {
+		sim.Advance(window.From)
+	}
+
+	// 机器人的请求全部转到模拟交易所
+	server := httptest.NewServer(sim)
+	defer server.Close()
+	simClient := binance.NewFuturesClient("", "")
+	simClient.BaseURL = server.URL
+	live := exchange
+	exchange = newBinanceExchange(simClient)
+	clock = sim.Now
+	defer func() {
+		exchange = live
+		clock = time.Now
+	}()
+	fundHistory = newFlowHistory(config.HistorySize)
+
+	summary := &backtestSummary{InitialBalance: balance, Signals: make(map[string]*signalStats)}
+	equity := make([]equityPoint, 0, len(paths))
+	for _, path := range paths {
+		// 文件名按记录时的时区 先粗略跳过相差一天以上的快照
+		if t, ok := cycleRecordTime(path); ok && !window.widen(24*time.Hour).contains(t) {
+			continue
+		}
+		record, err := readCycleRecord(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !window.contains(record.Time) {
+			continue
+		}
+		if summary.Start.IsZero() {
+			summary.Start = record.Time
+		}
+		summary.End = record.Time
+		sim.Advance(record.Time)
+		data, err := record.FundData()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		lastID := sim.LastOrderID()
+		symbolsFilter, err := runStrategy(data, nil)
+		if err != nil {
+			log.Println(err)
+		}
+		tags := make(map[string]string, len(symbolsFilter))
+		for _, s := range symbolsFilter {
+			tags[binanceSymbol(s.Coin)] = s.Signal
+		}
+		sim.TagOrders(lastID, tags)
+		equity = append(equity, equityPoint{Time: record.Time, Equity: sim.Equity()})
+		summary.Cycles++
+	}
+
+	summarize(summary, sim, equity)
+	if err := writeBacktest(outDir, summary, sim, equity); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// 汇总收益、回撤、胜率与分信号统计
+func summarize(summary *backtestSummary, sim *simExchange, equity []equityPoint) {
+	if len(equity) > 0 {
+		summary.FinalEquity = equity[len(equity)-1].Equity
+	} else {
+		summary.FinalEquity = summary.InitialBalance
+	}
+	summary.Return = summary.FinalEquity/summary.InitialBalance - 1
+	peak := summary.InitialBalance
+	for _, p := range equity {
+		peak = math.Max(peak, p.Equity)
+		if peak > 0 {
+			summary.MaxDrawdown = math.Max(summary.MaxDrawdown, (peak-p.Equity)/peak)
+		}
+	}
+	wins := 0
+	for _, trip := range sim.trips {
+		tag := trip.Tag
+		if tag == "" {
+			tag = "UNKNOWN"
+		}
+		stats, ok := summary.Signals[tag]
+		if !ok {
+			stats = &signalStats{}
+			summary.Signals[tag] = stats
+		}
+		stats.Trades++
+		stats.PnL += trip.PnL
+		if trip.PnL > 0 {
+			stats.Wins++
+			wins++
+		}
+	}
+	summary.Trades = len(sim.trips)
+	for _, f := range sim.fills {
+		summary.Fees += f.Fee
+	}
+	summary.Funding = sim.funding
+	if summary.Trades > 0 {
+		summary.WinRate = float64(wins) / float64(summary.Trades)
+	}
+	for _, stats := range summary.Signals {
+		stats.WinRate = float64(stats.Wins) / float64(stats.Trades)
+	}
+}
+
+// 输出 summary.json trades.csv fills.csv equity.csv
+func writeBacktest(outDir string, summary *backtestSummary, sim *simExchange, equity []equityPoint) error {
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "summary.json"), b, 0666); err != nil {
+		return err
+	}
+
+	trades := [][]string{{"symbol", "positionSide", "signal", "open", "close", "pnl"}}
+	for _, t := range sim.trips {
+		trades = append(trades, []string{t.Symbol, string(t.PositionSide), t.Tag, t.Open.Format(time.DateTime), t.Close.Format(time.DateTime), formatFloat(t.PnL)})
+	}
+	fills := [][]string{{"time", "orderId", "symbol", "side", "positionSide", "price", "quantity", "realized", "fee", "maker", "signal"}}
+	for _, f := range sim.fills {
+		fills = append(fills, []string{f.Time.Format(time.DateTime), strconv.FormatInt(f.OrderID, 10), f.Symbol, string(f.Side), string(f.PositionSide), formatFloat(f.Price), formatFloat(f.Quantity), formatFloat(f.Realized), formatFloat(f.Fee), strconv.FormatBool(f.Maker), f.Tag})
+	}
+	curve := [][]string{{"time", "equity"}}
+	for _, p := range equity {
+		curve = append(curve, []string{p.Time.Format(time.DateTime), formatFloat(p.Equity)})
+	}
+	for name, rows := range map[string][][]string{"trades.csv": trades, "fills.csv": fills, "equity.csv": curve} {
+		if err := writeCSV(filepath.Join(outDir, name), rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(path string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// 读取缓存的交易信息 没有时下载
+func loadExchangeInfo(market *futures.Client, path string) (*futures.ExchangeInfo, error) {
+	info := new(futures.ExchangeInfo)
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, info); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return info, nil
+	}
+	info, err := market.NewExchangeInfoService().Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	return info, writeFileAtomic(path, b)
+}
+
+// 读取缓存的历史K线 不覆盖 [start, end] 时重新下载
+func loadHistoryKlines(market *futures.Client, dataDir, symbol string, start, end time.Time) ([]*futures.Kline, error) {
+	path := filepath.Join(dataDir, symbol+"_"+backtestInterval+".json")
+	var klines []*futures.Kline
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &klines); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(klines) > 0 && klines[0].OpenTime <= start.UnixMilli() && klines[len(klines)-1].CloseTime >= end.UnixMilli() {
+			return klines, nil
+		}
+	}
+
+	klines = klines[:0]
+	for from := start.UnixMilli(); from < end.UnixMilli(); {
+		page, err := market.NewKlinesService().Symbol(symbol).Interval(backtestInterval).
+			StartTime(from).EndTime(end.UnixMilli()).Limit(backtestKlineLimit).Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		klines = append(klines, page...)
+		from = page[len(page)-1].CloseTime + 1
+	}
+	sort.Slice(klines, func(i, j int) bool { return klines[i].OpenTime < klines[j].OpenTime })
+	b, err := json.Marshal(klines)
+	if err != nil {
+		return nil, err
+	}
+	return klines, writeFileAtomic(path, b)
+}
+
+// 读取缓存的历史资金费率 不覆盖 [start, end] 时重新下载
+func loadFundingRates(market *futures.Client, dataDir, symbol string, start, end time.Time) ([]*futures.FundingRate, error) {
+	path := filepath.Join(dataDir, symbol+"_funding.json")
+	var rates []*futures.FundingRate
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &rates); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(rates) > 0 && rates[0].FundingTime <= start.Add(simFundingInterval).UnixMilli() && rates[len(rates)-1].FundingTime >= end.Add(-simFundingInterval).UnixMilli() {
+			return rates, nil
+		}
+	}
+
+	rates = rates[:0]
+	for from := start.UnixMilli(); from < end.UnixMilli(); {
+		page, err := market.NewFundingRateService().Symbol(symbol).StartTime(from).EndTime(end.UnixMilli()).Limit(1000).Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		rates = append(rates, page...)
+		from = page[len(page)-1].FundingTime + 1
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].FundingTime < rates[j].FundingTime })
+	b, err := json.Marshal(rates)
+	if err != nil {
+		return nil, err
+	}
+	return rates, writeFileAtomic(path, b)
+}
+
+// 先写临时文件再替换 并行回测共用缓存时不会读到写了一半的文件
+func writeFileAtomic(path string, b []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
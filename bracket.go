@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 默认 ATR 参数
+const (
+	atrDefaultLength   = 14
+	atrDefaultInterval = "5m"
+)
+
+// 持仓键 双向持仓下每个合约多空各一个
+type positionKey struct {
+	Symbol       string
+	PositionSide futures.PositionSideType
+}
+
+// 已挂止损止盈时的开仓均价 均价变化说明持仓数量变了 需要重新计算
+var bracketEntries = struct {
+	sync.Mutex
+	m map[positionKey]float64
+}{m: make(map[positionKey]float64)}
+
+// 开仓单成交通知 持仓管理收到后立即同步止损止盈 连续成交合并为一次
+var entryFills = make(chan struct{}, 1)
+
+func notifyEntryFill() {
+	select {
+	case entryFills <- struct{}{}:
+	default:
+	}
+}
+
+// 止损止盈单 条件市价单且 closePosition
+func isBracketOrder(order *futures.Order) bool {
+	return order.ClosePosition && isStopOrder(order.Type)
+}
+
+// 为每个持仓同步止损止盈单 持仓已平时撤掉残留的
+func syncBrackets() error {
+	if config.BracketMode == "" {
+		return nil
+	}
+	ctx := context.Background()
+	positions, err := exchange.Positions(ctx)
+	if err != nil {
+		return err
+	}
+	openOrders, err := exchange.OpenOrders(ctx)
+	if err != nil {
+		return err
+	}
+	brackets := make(map[positionKey][]*futures.Order)
+	for _, order := range openOrders {
+		if isBracketOrder(order) {
+			key := positionKey{order.Symbol, order.PositionSide}
+			brackets[key] = append(brackets[key], order)
+		}
+	}
+
+	bracketEntries.Lock()
+	defer bracketEntries.Unlock()
+	active := make(map[positionKey]bool)
+	for _, p := range positions {
+		amt, err := strconv.ParseFloat(p.PositionAmt, 64)
+		if err != nil || amt == 0 {
+			continue
+		}
+		key := positionKey{p.Symbol, p.PositionSide}
+		active[key] = true
+		entry, err := strconv.ParseFloat(p.EntryPrice, 64)
+		if err != nil || entry <= 0 {
+			continue
+		}
+		orders := brackets[key]
+		last, tracked := bracketEntries.m[key]
+		// 重启后沿用已有的止损止盈
+		entryChanged := tracked && last != entry
+		if hasBracketLegs(orders) && !entryChanged {
+			bracketEntries.m[key] = entry
+			continue
+		}
+		if err := syncBracketLegs(p.Symbol, p.PositionSide, entry, orders, entryChanged); err != nil {
+			log.Println(p.Symbol, p.PositionSide, "[BRACKET]", err)
+			continue
+		}
+		bracketEntries.m[key] = entry
+	}
+	for key, orders := range brackets {
+		if active[key] {
+			continue
+		}
+		for _, order := range orders {
+			log.Println(order.Symbol, order.PositionSide, "[BRACKET] 已平仓 撤销", order.Type)
+			cancelOrder(order.Symbol, order.OrderID)
+		}
+		delete(bracketEntries.m, key)
+	}
+	return nil
+}
+
+// 配置的止损止盈是否都已挂上
+func hasBracketLegs(orders []*futures.Order) bool {
+	var stop, take bool
+	for _, order := range orders {
+		switch order.Type {
+		case futures.OrderTypeStopMarket:
+			stop = true
+		case futures.OrderTypeTakeProfitMarket:
+			take = true
+		}
+	}
+	return (config.StopLoss <= 0 || stop) && (config.TakeProfit <= 0 || take)
+}
+
+// 按开仓均价逐条补挂或更新止损止盈 触发时平掉全部持仓
+// 均价没变时只补缺少的 均价变了时止盈重挂 止损只在新算出的更紧时重挂 不放松已移到保本的止损
+func syncBracketLegs(symbol string, positionSide futures.PositionSideType, entry float64, orders []*futures.Order, entryChanged bool) error {
+	stopDistance, takeDistance, err := bracketDistances(symbol, entry)
+	if err != nil {
+		return err
+	}
+	side := futures.SideTypeSell
+	if positionSide == futures.PositionSideTypeShort {
+		side = futures.SideTypeBuy
+	}
+	sign := sideSign(positionSide)
+	if config.StopLoss > 0 {
+		stopPrice := entry - sign*stopDistance
+		keep := func(current float64) bool {
+			return !entryChanged || sign*(current-stopPrice) >= 0
+		}
+		if err := syncBracketLeg(symbol, side, positionSide, futures.OrderTypeStopMarket, stopPrice, entry, orders, keep); err != nil {
+			return err
+		}
+	}
+	if config.TakeProfit > 0 {
+		keep := func(current float64) bool {
+			return !entryChanged
+		}
+		if err := syncBracketLeg(symbol, side, positionSide, futures.OrderTypeTakeProfitMarket, entry+sign*takeDistance, entry, orders, keep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 单条止损或止盈 已有的满足 keep 时保留 否则撤掉后按 stopPrice 重挂
+func syncBracketLeg(symbol string, side futures.SideType, positionSide futures.PositionSideType, orderType futures.OrderType, stopPrice, entry float64, orders []*futures.Order, keep func(current float64) bool) error {
+	for _, order := range orders {
+		if order.Type != orderType {
+			continue
+		}
+		current, err := strconv.ParseFloat(order.StopPrice, 64)
+		if err == nil && keep(current) {
+			return nil
+		}
+		if err := cancelOrder(order.Symbol, order.OrderID); err != nil {
+			return err
+		}
+	}
+	return placeCloseStop(symbol, side, positionSide, orderType, stopPrice, entry)
+}
+
+// 止损止盈距离 BracketMode: percent 按开仓价比例 atr 按 ATR 倍数
+func bracketDistances(symbol string, entry float64) (stop, take float64, err error) {
+	switch config.BracketMode {
+	case "percent":
+		return entry * config.StopLoss, entry * config.TakeProfit, nil
+	case "atr":
+		atr, err := symbolATR(symbol)
+		if err != nil {
+			return 0, 0, err
+		}
+		return atr * config.StopLoss, atr * config.TakeProfit, nil
+	default:
+		return 0, 0, fmt.Errorf("未知的 bracketMode %s", config.BracketMode)
+	}
+}
+
+// ATR 周期数与K线周期 未配置时用默认值
+func atrSettings() (int, string) {
+	length := config.AtrLength
+	if length <= 0 {
+		length = atrDefaultLength
+	}
+	interval := config.AtrInterval
+	if interval == "" {
+		interval = atrDefaultInterval
+	}
+	return length, interval
+}
+
+// 最新的 ATR 只用已收盘的K线
+func symbolATR(symbol string) (float64, error) {
+	length, interval := atrSettings()
+	klines, err := closedKlines(context.Background(), symbol, interval, length*3)
+	if err != nil {
+		return 0, err
+	}
+	// 新上线的合约K线可能不足 ATR 至少要 length+1 根
+	if len(klines) <= length {
+		return 0, fmt.Errorf("%s K线不足 无法计算 ATR", symbol)
+	}
+	ohlc := make([][]float64, 0, len(klines))
+	for _, k := range klines {
+		open, _ := strconv.ParseFloat(k.Open, 64)
+		high, _ := strconv.ParseFloat(k.High, 64)
+		low, _ := strconv.ParseFloat(k.Low, 64)
+		closePrice, _ := strconv.ParseFloat(k.Close, 64)
+		ohlc = append(ohlc, []float64{float64(k.OpenTime), open, high, low, closePrice})
+	}
+	atr := ATR(ohlc, length)
+	if len(atr) == 0 || atr[len(atr)-1] <= 0 {
+		return 0, fmt.Errorf("%s K线不足 无法计算 ATR", symbol)
+	}
+	return atr[len(atr)-1], nil
+}
+
+// 条件市价平仓单 触发价向参考价（开仓价或当前价）方向取整 不比设定的更远
+func placeCloseStop(symbol string, side futures.SideType, positionSide futures.PositionSideType, orderType futures.OrderType, stopPrice, entry float64) error {
+	if stopPrice <= 0 {
+		return fmt.Errorf("%s 触发价 %v 无效", orderType, stopPrice)
+	}
+	tickSize, err := symbolTickSize(symbol)
+	if err != nil {
+		return err
+	}
+	tick, err := strconv.ParseFloat(tickSize, 64)
+	if err != nil || tick <= 0 {
+		return fmt.Errorf("%s 无效的 tickSize %s", symbol, tickSize)
+	}
+	if stopPrice < entry {
+		stopPrice = math.Ceil(stopPrice/tick-1e-9) * tick
+	}
+	// takeDivisible 向下取整 加一点余量避免浮点误差少一档
+	stopStr, err := takeDivisible(stopPrice+tick*1e-6, tickSize)
+	if err != nil {
+		return err
+	}
+	log.Println(symbol, positionSide, "[BRACKET]", orderType, stopStr)
+	_, err = exchange.CreateOrder(context.Background(), OrderRequest{
+		Symbol:        symbol,
+		Side:          side,
+		PositionSide:  positionSide,
+		Type:          orderType,
+		StopPrice:     stopStr,
+		ClosePosition: true,
+	})
+	return err
+}
+
+// 合约价格最小变动单位
+func symbolTickSize(symbol string) (string, error) {
+	return symbolFilter(symbol, "PRICE_FILTER", "tickSize")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 当前的止损止盈触发价
+func bracketPrices(t *testing.T) (stop, take float64) {
+	t.Helper()
+	orders, err := exchange.OpenOrders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range orders {
+		price, _ := strconv.ParseFloat(order.StopPrice, 64)
+		switch order.Type {
+		case futures.OrderTypeStopMarket:
+			stop = price
+		case futures.OrderTypeTakeProfitMarket:
+			take = price
+		}
+	}
+	return stop, take
+}
+
+func TestSyncBracketsKeepsTighterStop(t *testing.T) {
+	sim, ex := newTestSim(t,
+		simTestKline(0, 100, 100, 100, 100, 10),
+		simTestKline(1, 100, 102, 100, 102, 10),
+	)
+	config.BracketMode = "percent"
+	config.StopLoss = 0.02
+	config.TakeProfit = 0.04
+	savedExchange, savedInfo := exchange, infoData
+	defer func() { exchange, infoData = savedExchange, savedInfo }()
+	exchange, infoData = ex, sim.info
+	bracketEntries.m = make(map[positionKey]float64)
+
+	long := OrderRequest{Side: futures.SideTypeBuy, PositionSide: futures.PositionSideTypeLong, Type: futures.OrderTypeMarket, Quantity: "1"}
+	simTestOrder(t, ex, long)
+	if err := syncBrackets(); err != nil {
+		t.Fatal(err)
+	}
+	stop, take := bracketPrices(t)
+	// 开仓价 100.1 止损向开仓价方向取整
+	if stop != 98.1 || take != 104.1 {
+		t.Fatalf("brackets = %v/%v", stop, take)
+	}
+
+	// 价格上涨后止损已移到保本
+	sim.Advance(simTestStart.Add(10 * time.Minute))
+	orders, _ := ex.OpenOrders(context.Background())
+	for _, order := range orders {
+		if order.Type == futures.OrderTypeStopMarket {
+			if err := ex.CancelOrder(context.Background(), order.Symbol, order.OrderID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	simTestOrder(t, ex, OrderRequest{Side: futures.SideTypeSell, PositionSide: futures.PositionSideTypeLong,
+		Type: futures.OrderTypeStopMarket, StopPrice: "100.1", ClosePosition: true})
+
+	// 加仓后均价变为 101.1 新算出的止损 99.1 比保本宽 保留保本止损 止盈按新均价重挂
+	simTestOrder(t, ex, long)
+	if err := syncBrackets(); err != nil {
+		t.Fatal(err)
+	}
+	stop, take = bracketPrices(t)
+	if stop != 100.1 || take != 105.1 {
+		t.Fatalf("brackets after entry change = %v/%v", stop, take)
+	}
+}
+
+func TestSymbolATRShortHistory(t *testing.T) {
+	savedConfig, savedExchange := config, exchange
+	defer func() { config, exchange = savedConfig, savedExchange }()
+	config = Config{AtrLength: 3}
+	// 已收盘的K线正好 3 根
+	exchange = &klineExchange{klines: map[string][]*futures.Kline{
+		"NEWUSDT": testKlines(func(i int) float64 { return 1 + float64(i) }, 3),
+	}}
+	if _, err := symbolATR("NEWUSDT"); err == nil {
+		t.Fatal("expected an error for too few klines")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 追价挂单的首次挂单价 用于限制最大追价距离 重启后以当时的挂单价为准 由 ordersMu 保护
+var chaseOrigins = make(map[int64]float64)
+
+// 追价 挂单向买一卖一移动 ChaseTicks 个最小变动单位 不越过盘口
+// 已到最大追价距离还未成交时撤单 返回是否已撤单
+func chaseOrder(order *futures.Order) (bool, error) {
+	price, err := strconv.ParseFloat(order.Price, 64)
+	if err != nil {
+		return false, err
+	}
+	origin, ok := chaseOrigins[order.OrderID]
+	if !ok {
+		origin = price
+		chaseOrigins[order.OrderID] = origin
+	}
+	tickSize, err := symbolTickSize(order.Symbol)
+	if err != nil {
+		return false, err
+	}
+	book, err := exchange.Depth(context.Background(), order.Symbol, 5)
+	if err != nil {
+		return false, err
+	}
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		log.Println(order.Symbol, "[CHASE] 订单簿为空")
+		return false, nil
+	}
+	bid, _ := strconv.ParseFloat(book.Bids[0].Price, 64)
+	ask, _ := strconv.ParseFloat(book.Asks[0].Price, 64)
+	ticks := config.ChaseTicks
+	if ticks <= 0 {
+		ticks = 1
+	}
+
+	// 买单向上 卖单向下 sign 为追价方向
+	sign, best, bestPrice := 1.0, bid, book.Bids[0].Price
+	if order.Side == futures.SideTypeSell {
+		sign, best, bestPrice = -1, ask, book.Asks[0].Price
+	}
+	if sign*(price-best) >= 0 {
+		// 已经在盘口 继续排队
+		return false, nil
+	}
+	// 按最小变动单位的整数倍计算 避免浮点误差取整后退回原价
+	step := new(big.Rat).Mul(big.NewRat(int64(sign)*int64(ticks), 1), parseDecimal(tickSize))
+	target := new(big.Rat).Add(parseDecimal(order.Price), step)
+	if sign*float64(target.Cmp(parseDecimal(bestPrice))) > 0 {
+		target = parseDecimal(bestPrice)
+	}
+	if config.ChaseMaxDistance > 0 {
+		limit := decimalOf(origin * (1 + sign*config.ChaseMaxDistance))
+		if sign*float64(target.Cmp(limit)) > 0 {
+			target = limit
+		}
+	}
+	// 买单向下 卖单向上取整 不越过盘口和最大追价距离
+	mode := roundFloor
+	if order.Side == futures.SideTypeSell {
+		mode = roundCeil
+	}
+	targetPrice, err := roundStep(target, tickSize, mode)
+	if err != nil {
+		return false, err
+	}
+	req := OrderRequest{Symbol: order.Symbol, Side: order.Side, Type: futures.OrderTypeLimit, Price: targetPrice, Quantity: order.OrigQuantity}
+	if err := normalizeOrder(&req, formatFloat((bid+ask)/2), -1); err != nil {
+		return false, err
+	}
+	if moved := parseDecimal(req.Price).Cmp(parseDecimal(order.Price)); sign*float64(moved) <= 0 {
+		log.Println(order.Symbol, "[CHASE] 超过最大追价距离", config.ChaseMaxDistance, "撤单")
+		if err := cancelOrder(order.Symbol, order.OrderID); err != nil {
+			return false, err
+		}
+		delete(chaseOrigins, order.OrderID)
+		return true, nil
+	}
+	log.Println(order.Symbol, order.PositionSide, "[CHASE]", order.Price, "->", req.Price)
+	return false, exchange.ModifyOrder(context.Background(), order.Symbol, order.OrderID, order.Side, req.Quantity, req.Price)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+func TestChaseOrderMovesOneTick(t *testing.T) {
+	sim, ex := newTestSim(t, simTestKline(0, 100, 100, 100, 100, 10))
+	savedExchange, savedInfo := exchange, infoData
+	defer func() { exchange, infoData = savedExchange, savedInfo }()
+	exchange, infoData = ex, sim.info
+
+	// 97.1+0.1 的浮点结果是 97.19999999999999 向下取整会退回 97.1
+	simTestOrder(t, ex, OrderRequest{Side: futures.SideTypeBuy, PositionSide: futures.PositionSideTypeLong,
+		Type: futures.OrderTypeLimit, TimeInForce: futures.TimeInForceTypeGTC, Quantity: "1", Price: "97.1"})
+	orders, err := ex.OpenOrders(context.Background())
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("orders = %v, %v", orders, err)
+	}
+	defer delete(chaseOrigins, orders[0].OrderID)
+	canceled, err := chaseOrder(orders[0])
+	if err != nil || canceled {
+		t.Fatalf("chaseOrder = %v, %v", canceled, err)
+	}
+	orders, _ = ex.OpenOrders(context.Background())
+	if len(orders) != 1 || orders[0].Price != "97.2" {
+		t.Fatalf("orders after chase = %+v", orders)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const coinankFundRealURL = "https://coinank.com/api/fund/fundReal"
+
+// 默认最大翻页数
+const coinankDefaultMaxPages = 10
+
+// Coinank 错误分类
+var (
+	ErrCoinankAuth   = errors.New("coinank: apikey 校验失败")
+	ErrCoinankSchema = errors.New("coinank: 数据结构不匹配")
+)
+
+// HTTP 状态码错误
+type CoinankStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *CoinankStatusError) Error() string {
+	return fmt.Sprintf("coinank: 请求失败，状态码: %d %s", e.StatusCode, e.Body)
+}
+
+// success=false 错误
+type CoinankAPIError struct {
+	Code string
+	Msg  string
+}
+
+func (e *CoinankAPIError) Error() string {
+	return fmt.Sprintf("coinank: API返回失败 code=%s msg=%s", e.Code, e.Msg)
+}
+
+// fundReal 列表项
+type CoinankFund struct {
+	BaseCoin string  `json:"baseCoin"`
+	M5Net    float64 `json:"m5net"`
+	M15Net   float64 `json:"m15net"`
+	M30Net   float64 `json:"m30net"`
+	H1Net    float64 `json:"h1net"`
+	H2Net    float64 `json:"h2net"`
+	H4Net    float64 `json:"h4net"`
+	H6Net    float64 `json:"h6net"`
+	H8Net    float64 `json:"h8net"`
+	H12Net   float64 `json:"h12net"`
+	D1Net    float64 `json:"d1net"`
+	D2Net    float64 `json:"d2net"`
+	D3Net    float64 `json:"d3net"`
+	D5Net    float64 `json:"d5net"`
+	D7Net    float64 `json:"d7net"`
+}
+
+// fundReal 查询参数
+type CoinankFundQuery struct {
+	Page        int
+	Size        int
+	Type        int
+	ProductType string
+	SortBy      string
+}
+
+// 从配置生成查询参数
+func coinankQueryFromConfig() CoinankFundQuery {
+	query := CoinankFundQuery{
+		Page:        1,
+		Size:        config.CoinankPageSize,
+		Type:        config.CoinankType,
+		ProductType: config.CoinankProductType,
+		SortBy:      config.CoinankSortBy,
+	}
+	if query.Size <= 0 {
+		query.Size = 50
+	}
+	if query.Type == 0 {
+		query.Type = 1
+	}
+	if query.ProductType == "" {
+		query.ProductType = "SWAP"
+	}
+	return query
+}
+
+// 生成请求地址
+func (q CoinankFundQuery) URL() string {
+	values := url.Values{}
+	values.Set("page", strconv.Itoa(q.Page))
+	values.Set("size", strconv.Itoa(q.Size))
+	values.Set("type", strconv.Itoa(q.Type))
+	values.Set("productType", q.ProductType)
+	values.Set("sortBy", q.SortBy)
+	values.Set("baseCoin", "")
+	values.Set("isFollow", "false")
+	return coinankFundRealURL + "?" + values.Encode()
+}
+
+// 必须存在的字段
+var coinankRequiredFields = []string{"baseCoin", "m5net", "m15net"}
+
+// fundReal 响应
+type coinankResponse struct {
+	Success bool   `json:"success"`
+	Code    any    `json:"code"`
+	Msg     string `json:"msg"`
+	Data    *struct {
+		List []json.RawMessage `json:"list"`
+	} `json:"data"`
+}
+
+// Coinank 客户端
+type CoinankClient struct {
+	HTTPClient *http.Client
+}
+
+var coinankClient *CoinankClient
+
+func NewCoinankClient(httpClient *http.Client) *CoinankClient {
+	return &CoinankClient{HTTPClient: httpClient}
+}
+
+// Coinank-Apikey 获取
+func getKey() string {
+	signStr := fmt.Sprintf("%s|%d%d", "-b31e-c547-d299-b6d07b7631aba2c903cca2c903cc", time.Now().UnixNano()/int64(time.Millisecond)+1111111111111, 347)
+	originalBytes := []byte(signStr)
+	base64Bytes := base64.StdEncoding.EncodeToString(originalBytes)
+	return base64Bytes
+}
+
+// 取 fundReal 单页 原始响应记入 record
+func (c *CoinankClient) FundReal(ctx context.Context, query CoinankFundQuery, record *cycleRecord) ([]CoinankFund, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", query.URL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("coinank-apikey", getKey())
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	record.AddCoinank(query, resp.StatusCode, body)
+	return parseFundReal(resp.StatusCode, body)
+}
+
+// 自动翻页 直到覆盖 wanted 中的全部币种、数据取完或达到 maxPages
+func (c *CoinankClient) FundRealAll(ctx context.Context, query CoinankFundQuery, wanted []string, maxPages int, record *cycleRecord) ([]CoinankFund, error) {
+	if maxPages <= 0 {
+		maxPages = coinankDefaultMaxPages
+	}
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	remaining := make(map[string]struct{}, len(wanted))
+	for _, coin := range wanted {
+		remaining[coin] = struct{}{}
+	}
+
+	funds := make([]CoinankFund, 0)
+	seen := make(map[string]struct{})
+	for i := 0; i < maxPages; i++ {
+		page, err := c.FundReal(ctx, query, record)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", query.Page, err)
+		}
+		for _, fund := range page {
+			// 翻页期间排名变动可能导致重复
+			if _, ok := seen[fund.BaseCoin]; ok {
+				continue
+			}
+			seen[fund.BaseCoin] = struct{}{}
+			delete(remaining, fund.BaseCoin)
+			funds = append(funds, fund)
+		}
+		if len(page) < query.Size || (len(wanted) > 0 && len(remaining) == 0) {
+			break
+		}
+		query.Page++
+	}
+	return funds, nil
+}
+
+// 解析 fundReal 响应
+func parseFundReal(statusCode int, body []byte) ([]CoinankFund, error) {
+	if statusCode != http.StatusOK {
+		statusErr := &CoinankStatusError{StatusCode: statusCode, Body: truncate(string(body), 200)}
+		if statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("%w: %w", ErrCoinankAuth, statusErr)
+		}
+		return nil, statusErr
+	}
+
+	var response coinankResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCoinankSchema, err)
+	}
+	if !response.Success {
+		apiErr := &CoinankAPIError{Msg: response.Msg}
+		if response.Code != nil {
+			apiErr.Code = fmt.Sprint(response.Code)
+		}
+		if isCoinankAuthCode(apiErr.Code) {
+			return nil, fmt.Errorf("%w: %w", ErrCoinankAuth, apiErr)
+		}
+		return nil, apiErr
+	}
+	if response.Data == nil || response.Data.List == nil {
+		return nil, fmt.Errorf("%w: 缺少 data.list", ErrCoinankSchema)
+	}
+
+	funds := make([]CoinankFund, 0, len(response.Data.List))
+	for i, raw := range response.Data.List {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, fmt.Errorf("%w: list[%d]: %w", ErrCoinankSchema, i, err)
+		}
+		for _, name := range coinankRequiredFields {
+			if v, ok := fields[name]; !ok || string(v) == "null" {
+				return nil, fmt.Errorf("%w: list[%d] 缺少字段 %s", ErrCoinankSchema, i, name)
+			}
+		}
+		var fund CoinankFund
+		if err := json.Unmarshal(raw, &fund); err != nil {
+			return nil, fmt.Errorf("%w: list[%d]: %w", ErrCoinankSchema, i, err)
+		}
+		funds = append(funds, fund)
+	}
+	return funds, nil
+}
+
+// apikey 校验失败时返回的错误码 与 HTTP 401/403 对应
+var coinankAuthCodes = []string{"401", "403"}
+
+func isCoinankAuthCode(code string) bool {
+	for _, c := range coinankAuthCodes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// 截断字符串
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// 取Coinank数据
+func fetchFundCoinankData(record *cycleRecord) ([]FundData, error) {
+	funds, err := coinankClient.FundRealAll(context.Background(), coinankQueryFromConfig(), symbolsString, config.CoinankMaxPages, record)
+	if err != nil {
+		return nil, err
+	}
+	// 现货资金流 用于期现背离
+	var spot []CoinankFund
+	if config.DivergenceAmount > 0 {
+		query := coinankQueryFromConfig()
+		query.ProductType = "SPOT"
+		spot, err = coinankClient.FundRealAll(context.Background(), query, symbolsString, config.CoinankMaxPages, record)
+		if err != nil {
+			// 只影响本轮的期现背离
+			log.Println("[SPOT]", err)
+			spot = nil
+		}
+	}
+	return fundDataFromCoinank(funds, spot), nil
+}
+
+// 合约与现货资金流转为 FundData
+func fundDataFromCoinank(funds []CoinankFund, spot []CoinankFund) []FundData {
+	spotFunds := make(map[string]CoinankFund, len(spot))
+	for _, fund := range spot {
+		spotFunds[fund.BaseCoin] = fund
+	}
+
+	fundDataList := make([]FundData, 0)
+	for _, fund := range funds {
+		// 检查是否在名单中
+		if contains(symbolsString, fund.BaseCoin) {
+			spotFund, hasSpot := spotFunds[fund.BaseCoin]
+			fundDataList = append(fundDataList, FundData{
+				Coin:   fund.BaseCoin,
+				M5Net:  fund.M5Net,
+				M15Net: fund.M15Net,
+				M30Net: fund.M30Net,
+				H1Net:  fund.H1Net,
+				H4Net:  fund.H4Net,
+				D1Net:  fund.D1Net,
+
+				SpotMissing: !hasSpot,
+				SpotM5Net:   spotFund.M5Net,
+				SpotM15Net:  spotFund.M15Net,
+			})
+		}
+	}
+
+	return fundDataList
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseFundReal(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		wantErr    error
+		wantStatus int
+		wantAPI    bool
+		wantCoins  int
+	}{
+		{
+			name:       "ok",
+			statusCode: http.StatusOK,
+			body:       `{"success":true,"code":"1","data":{"list":[{"baseCoin":"BTC","m5net":1.5,"m15net":-2,"h1net":3},{"baseCoin":"ETH","m5net":0,"m15net":0}]}}`,
+			wantCoins:  2,
+		},
+		{
+			name:       "empty list",
+			statusCode: http.StatusOK,
+			body:       `{"success":true,"data":{"list":[]}}`,
+		},
+		{
+			name:       "http status",
+			statusCode: http.StatusBadGateway,
+			body:       `bad gateway`,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "http auth",
+			statusCode: http.StatusUnauthorized,
+			body:       ``,
+			wantErr:    ErrCoinankAuth,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "auth code string",
+			statusCode: http.StatusOK,
+			body:       `{"success":false,"code":"401","msg":"apikey invalid"}`,
+			wantErr:    ErrCoinankAuth,
+			wantAPI:    true,
+		},
+		{
+			name:       "auth code number",
+			statusCode: http.StatusOK,
+			body:       `{"success":false,"code":403,"msg":"forbidden"}`,
+			wantErr:    ErrCoinankAuth,
+			wantAPI:    true,
+		},
+		{
+			// msg 含 key 但不是鉴权错误码
+			name:       "success false",
+			statusCode: http.StatusOK,
+			body:       `{"success":false,"code":"500","msg":"missing sortBy key"}`,
+			wantAPI:    true,
+		},
+		{
+			name:       "not json",
+			statusCode: http.StatusOK,
+			body:       `<html></html>`,
+			wantErr:    ErrCoinankSchema,
+		},
+		{
+			name:       "missing list",
+			statusCode: http.StatusOK,
+			body:       `{"success":true,"data":{}}`,
+			wantErr:    ErrCoinankSchema,
+		},
+		{
+			name:       "missing field",
+			statusCode: http.StatusOK,
+			body:       `{"success":true,"data":{"list":[{"baseCoin":"BTC","m5net":1}]}}`,
+			wantErr:    ErrCoinankSchema,
+		},
+		{
+			name:       "null field",
+			statusCode: http.StatusOK,
+			body:       `{"success":true,"data":{"list":[{"baseCoin":"BTC","m5net":null,"m15net":1}]}}`,
+			wantErr:    ErrCoinankSchema,
+		},
+		{
+			name:       "wrong type",
+			statusCode: http.StatusOK,
+			body:       `{"success":true,"data":{"list":[{"baseCoin":"BTC","m5net":"1.5","m15net":1}]}}`,
+			wantErr:    ErrCoinankSchema,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			funds, err := parseFundReal(tt.statusCode, []byte(tt.body))
+			wantAnyErr := tt.wantErr != nil || tt.wantStatus != 0 || tt.wantAPI
+			if !wantAnyErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(funds) != tt.wantCoins {
+					t.Fatalf("got %d funds, want %d", len(funds), tt.wantCoins)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v is not %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (errors.Is(err, ErrCoinankAuth) || errors.Is(err, ErrCoinankSchema)) {
+				t.Errorf("error %v should not be classified", err)
+			}
+			var statusErr *CoinankStatusError
+			if got := errors.As(err, &statusErr); got != (tt.wantStatus != 0) {
+				t.Errorf("CoinankStatusError = %v, want %v", got, tt.wantStatus != 0)
+			} else if got && statusErr.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", statusErr.StatusCode, tt.wantStatus)
+			}
+			var apiErr *CoinankAPIError
+			if got := errors.As(err, &apiErr); got != tt.wantAPI {
+				t.Errorf("CoinankAPIError = %v, want %v", got, tt.wantAPI)
+			}
+		})
+	}
+
+	funds, err := parseFundReal(http.StatusOK, []byte(tests[0].body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if funds[0].BaseCoin != "BTC" || funds[0].M5Net != 1.5 || funds[0].M15Net != -2 || funds[0].H1Net != 3 {
+		t.Errorf("funds[0] = %+v", funds[0])
+	}
+}
@@ -27,9 +27,78 @@ type Config struct {
 	MultipleNetAmount     float64  `json:"multipleNetAmount"`     // 挂单量倍数 5分钟的n倍>15分钟
 	MarginUtilizationRate float64  `json:"marginUtilizationRate"` // 仓位使用率
 	Blacklist             []string `json:"blacklist"`             // 黑名单
+	Source                string   `json:"source"`                // 资金流数据源 coinank/file/binance/replay
+	SourceFile            string   `json:"sourceFile"`            // 文件数据源路径（JSON Lines）
+	AggTradeFile          string   `json:"aggTradeFile"`          // binance 数据源 回放的 aggTrade 录制文件 每轮向后回放 duration 秒 为空时连接实时行情
+	AggTradeRecordFile    string   `json:"aggTradeRecordFile"`    // binance 数据源 实时 aggTrade 录制文件
+	ConfirmHorizons       []string `json:"confirmHorizons"`       // 多周期确认 m15/m30/h1/h4/d1 净流入须与5分钟方向一致 为空不确认
+	ConfirmMinNet         float64  `json:"confirmMinNet"`         // 多周期确认 最小净流入金额
+	DivergenceAmount      float64  `json:"divergenceAmount"`      // 期现背离 合约与现货5分钟净流入方向相反时的差额 大于0时拉取现货资金流
+	ThresholdMode         string   `json:"thresholdMode"`         // 资金流阈值模式 absolute(BuyNetAmount/SideNetAmount)/zscore/percentile/volume
+	ZScoreLevel           float64  `json:"zScoreLevel"`           // zscore 模式 M5Net 与 M15Net 相对该币种各自历史的标准分
+	PercentileLevel       float64  `json:"percentileLevel"`       // percentile 模式 M5Net 与 M15Net 的做多百分位 做空为 100-该值
+	VolumeNetRatio        float64  `json:"volumeNetRatio"`        // volume 模式 M5Net/24小时成交额
+	HistorySize           int      `json:"historySize"`           // 每个币种保留的资金流快照数 默认1440
+	HistoryMinSamples     int      `json:"historyMinSamples"`     // zscore/percentile 最少样本数 默认30
+	CoinankPageSize       int      `json:"coinankPageSize"`       // Coinank 每页数量 默认50
+	CoinankMaxPages       int      `json:"coinankMaxPages"`       // Coinank 最大翻页数 默认10
+	CoinankType           int      `json:"coinankType"`           // Coinank type 参数 默认1
+	CoinankProductType    string   `json:"coinankProductType"`    // Coinank productType 参数 默认SWAP
+	CoinankSortBy         string   `json:"coinankSortBy"`         // Coinank sortBy 参数
+
+	SideRules []SideRule `json:"sideRules"` // 多空判断分层规则 按顺序匹配 未匹配时 M5Net>50万为多 <-50万为空
+
+	SymbolMapFile string `json:"symbolMapFile"` // Coinank 币种与 Binance 合约映射文件 覆盖内置的 1000/1M 前缀规则
+
+	RecordDir string `json:"recordDir"` // 每轮输入快照目录 为空不记录
+	ReplayDir string `json:"replayDir"` // replay 数据源读取的快照目录
+
+	SimLeverage    float64 `json:"simLeverage"`    // 模拟交易所杠杆 默认10
+	SimMakerFee    float64 `json:"simMakerFee"`    // 模拟交易所挂单手续费率 默认0.0002
+	SimTakerFee    float64 `json:"simTakerFee"`    // 模拟交易所吃单手续费率 默认0.0005
+	SimFundingRate float64 `json:"simFundingRate"` // 模拟交易所资金费率 每8小时 默认0.0001 回测优先使用历史资金费率
+
+	Mode         string  `json:"mode"`         // 运行模式 live/paper paper 时使用实盘行情 下单走本地模拟账户
+	PaperFile    string  `json:"paperFile"`    // 模拟盘账户文件 默认 paper.json
+	PaperBalance float64 `json:"paperBalance"` // 模拟盘初始余额 默认1000
+
+	BracketMode string  `json:"bracketMode"` // 止损止盈 percent 按开仓价比例 / atr 按ATR倍数 为空不挂
+	StopLoss    float64 `json:"stopLoss"`    // 止损距离 0不挂
+	TakeProfit  float64 `json:"takeProfit"`  // 止盈距离 0不挂
+	AtrLength   int     `json:"atrLength"`   // ATR 长度 默认14
+	AtrInterval string  `json:"atrInterval"` // ATR K线周期 默认5m
+
+	ManageInterval    int     `json:"manageInterval"`    // 持仓管理间隔 s 0时只在每轮策略中和开仓单成交时执行
+	BreakEvenProfit   float64 `json:"breakEvenProfit"`   // 浮盈达到开仓价的该比例后止损移到保本 0不启用
+	BreakEvenOffset   float64 `json:"breakEvenOffset"`   // 保本止损相对开仓价的有利偏移比例 覆盖手续费
+	TrailMode         string  `json:"trailMode"`         // 移动止损 callback(TRAILING_STOP_MARKET)/atr(本地ATR跟踪) 为空不启用
+	TrailActivation   float64 `json:"trailActivation"`   // 浮盈达到开仓价的该比例后启动移动止损 0立即启动
+	TrailCallbackRate float64 `json:"trailCallbackRate"` // callback 模式回调比例 % 0.1-5
+	TrailAtr          float64 `json:"trailAtr"`          // atr 模式 止损距启动后最优价的ATR倍数
+
+	MinNotionalBump bool `json:"minNotionalBump"` // 名义价值低于交易规则 MIN_NOTIONAL 时 true 提高数量到最小值 false 拒绝下单
+
+	PriceMode     string  `json:"priceMode"`     // 开仓挂单价格 level(PriceDepth档)/notional(前方累计挂单金额)/atr(中间价偏移ATR)/postonly(买一卖一 GTX) 默认level
+	PriceNotional float64 `json:"priceNotional"` // notional 模式 前方累计挂单金额 USDT
+	PriceAtr      float64 `json:"priceAtr"`      // atr 模式 距中间价的ATR倍数
+
+	OrderMode        string  `json:"orderMode"`        // 开仓挂单处理 timeout(超时撤单)/chase(超时改价追单 信号消失撤单) 默认timeout
+	ChaseTicks       int     `json:"chaseTicks"`       // chase 模式 每次追价的最小变动单位数 默认1
+	ChaseMaxDistance float64 `json:"chaseMaxDistance"` // chase 模式 相对首次挂单价的最大追价比例 到达后仍未成交则撤单 0不限制
+
+	SignalGrace int `json:"signalGrace"` // 信号消失后开仓挂单的保留时间 s 超过后撤单 不配置或不大于0时不按信号撤单 只按超时处理
+
+	UserStream       bool `json:"userStream"`       // 订阅用户数据流 挂单从内存读取 成交后立即同步止损止盈 paper 模式不使用
+	UserStreamResync int  `json:"userStreamResync"` // 用户数据流定时用 REST 快照重建的间隔 分钟 默认30
+
+	DepthStream bool `json:"depthStream"` // 用增量深度流维护本地订单簿 下单读取本地深度
+	DepthStale  int  `json:"depthStale"`  // 本地订单簿超过该时间 ms 没有更新视为过期 改用 REST 默认10000
+
+	KlineStream bool `json:"klineStream"` // K线缓存 第一次读取时 REST 回填 之后由K线流更新并自动补齐缺口
 }
 
-func init() {
+// 读取 config.json
+func loadConfig() {
 	b, err := os.ReadFile("config.json")
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 本地订单簿参数
+const (
+	depthSnapshotLimit    = 1000
+	depthBufferSize       = 4096
+	depthDefaultStale     = 10000            // 默认过期时间 ms
+	depthBookIdle         = 30 * time.Minute // 超过该时间没有读取的订单簿停止订阅
+	depthBookRetry        = 3 * time.Second
+	depthBookIdleInterval = time.Minute
+)
+
+// 单个合约的本地订单簿 增量深度流加 REST 快照
+// 快照之后第一条事件须满足 U <= lastUpdateId <= u 之后每条事件的 pu 须等于上一条的 u 否则重建
+type localBook struct {
+	symbol string
+
+	mu       sync.Mutex
+	synced   bool
+	lastID   int64
+	time     int64 // 最后一条事件的时间 ms
+	updated  time.Time
+	lastRead time.Time
+	bids     map[string]string
+	asks     map[string]string
+
+	stop chan struct{}
+}
+
+// 增量事件不连续
+var errDepthGap = errors.New("深度事件不连续")
+
+func newLocalBook(symbol string) *localBook {
+	return &localBook{symbol: symbol, lastRead: time.Now(), stop: make(chan struct{})}
+}
+
+// 订阅并维护订单簿 断线或事件不连续时重新取快照
+func (b *localBook) run(rest Exchange) {
+	for {
+		err := b.serve(rest)
+		b.mu.Lock()
+		b.synced = false
+		b.mu.Unlock()
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+		if err != nil {
+			log.Println(b.symbol, "[DEPTH]", err, "重建")
+		}
+		time.Sleep(depthBookRetry)
+	}
+}
+
+func (b *localBook) serve(rest Exchange) error {
+	events := make(chan *futures.WsDepthEvent, depthBufferSize)
+	overflow := make(chan struct{}, 1)
+	doneC, stopC, err := futures.WsDiffDepthServe(b.symbol, func(event *futures.WsDepthEvent) {
+		select {
+		case events <- event:
+		default:
+			select {
+			case overflow <- struct{}{}:
+			default:
+			}
+		}
+	}, func(err error) {
+		log.Println(b.symbol, "[DEPTH]", err)
+	})
+	if err != nil {
+		return err
+	}
+	defer close(stopC)
+	// 先订阅再取快照 快照之前的事件丢弃
+	snapshot, err := rest.Depth(context.Background(), b.symbol, depthSnapshotLimit)
+	if err != nil {
+		return err
+	}
+	b.reset(snapshot)
+	started := false
+	for {
+		select {
+		case <-b.stop:
+			return nil
+		case <-doneC:
+			return fmt.Errorf("断开")
+		case <-overflow:
+			return fmt.Errorf("事件积压")
+		case event := <-events:
+			if !started {
+				if event.LastUpdateID < snapshot.LastUpdateID {
+					continue
+				}
+				if event.FirstUpdateID > snapshot.LastUpdateID {
+					return errDepthGap
+				}
+				started = true
+			} else if event.PrevLastUpdateID != b.lastID {
+				return errDepthGap
+			}
+			b.apply(event)
+		}
+	}
+}
+
+// 用快照重置 收到第一条可用事件前不可读
+func (b *localBook) reset(snapshot *futures.DepthResponse) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.synced = false
+	b.lastID = snapshot.LastUpdateID
+	b.bids = make(map[string]string, len(snapshot.Bids))
+	b.asks = make(map[string]string, len(snapshot.Asks))
+	for _, level := range snapshot.Bids {
+		b.bids[level.Price] = level.Quantity
+	}
+	for _, level := range snapshot.Asks {
+		b.asks[level.Price] = level.Quantity
+	}
+}
+
+// 应用增量 数量为0的价位删除
+func (b *localBook) apply(event *futures.WsDepthEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	update := func(side map[string]string, price, quantity string) {
+		if qty, err := strconv.ParseFloat(quantity, 64); err == nil && qty == 0 {
+			delete(side, price)
+		} else {
+			side[price] = quantity
+		}
+	}
+	for _, level := range event.Bids {
+		update(b.bids, level.Price, level.Quantity)
+	}
+	for _, level := range event.Asks {
+		update(b.asks, level.Price, level.Quantity)
+	}
+	b.lastID = event.LastUpdateID
+	b.time = event.Time
+	b.updated = time.Now()
+	b.synced = true
+}
+
+// 前 limit 档 未同步、过期或买卖价交叉时返回 false
+func (b *localBook) depth(limit int, stale time.Duration) (*futures.DepthResponse, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastRead = time.Now()
+	if !b.synced || time.Since(b.updated) > stale {
+		return nil, false
+	}
+	bids := sortedLevels(b.bids, limit, true)
+	asks := sortedLevels(b.asks, limit, false)
+	if len(bids) == 0 || len(asks) == 0 {
+		return nil, false
+	}
+	bid, _ := strconv.ParseFloat(bids[0].Price, 64)
+	ask, _ := strconv.ParseFloat(asks[0].Price, 64)
+	if bid >= ask {
+		return nil, false
+	}
+	return &futures.DepthResponse{LastUpdateID: b.lastID, Time: b.time, TradeTime: b.time, Bids: bids, Asks: asks}, true
+}
+
+// 按价格排序取前 limit 档 买盘从高到低 卖盘从低到高
+func sortedLevels(side map[string]string, limit int, desc bool) []futures.Bid {
+	prices := make([]float64, 0, len(side))
+	byPrice := make(map[float64]string, len(side))
+	for price := range side {
+		p, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			continue
+		}
+		prices = append(prices, p)
+		byPrice[p] = price
+	}
+	if desc {
+		sort.Sort(sort.Reverse(sort.Float64Slice(prices)))
+	} else {
+		sort.Float64s(prices)
+	}
+	if len(prices) > limit {
+		prices = prices[:limit]
+	}
+	levels := make([]futures.Bid, 0, len(prices))
+	for _, p := range prices {
+		price := byPrice[p]
+		levels = append(levels, futures.Bid{Price: price, Quantity: side[price]})
+	}
+	return levels
+}
+
+// 深度优先读本地订单簿 第一次读取某个合约时开始订阅 订单簿不可用时走 REST
+type depthBookExchange struct {
+	Exchange
+
+	mu    sync.Mutex
+	books map[string]*localBook
+}
+
+func newDepthBookExchange(live Exchange) *depthBookExchange {
+	d := &depthBookExchange{Exchange: live, books: make(map[string]*localBook)}
+	go d.expire()
+	return d
+}
+
+func (d *depthBookExchange) Depth(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error) {
+	d.mu.Lock()
+	book, watched := d.books[symbol]
+	if !watched {
+		book = newLocalBook(symbol)
+		d.books[symbol] = book
+		go book.run(d.Exchange)
+	}
+	d.mu.Unlock()
+	stale := time.Duration(config.DepthStale) * time.Millisecond
+	if stale <= 0 {
+		stale = depthDefaultStale * time.Millisecond
+	}
+	if res, ok := book.depth(limit, stale); ok {
+		return res, nil
+	}
+	if watched {
+		log.Println(symbol, "[DEPTH] 本地订单簿不可用 使用 REST")
+	}
+	return d.Exchange.Depth(ctx, symbol, limit)
+}
+
+// 停止长时间没有读取的订单簿
+func (d *depthBookExchange) expire() {
+	for {
+		time.Sleep(depthBookIdleInterval)
+		d.mu.Lock()
+		for symbol, book := range d.books {
+			book.mu.Lock()
+			idle := time.Since(book.lastRead) > depthBookIdle
+			book.mu.Unlock()
+			if idle {
+				close(book.stop)
+				delete(d.books, symbol)
+			}
+		}
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 交易所接口 策略只通过它访问账户、订单和行情
+type Exchange interface {
+	// 同步服务器时间
+	SyncTime(ctx context.Context) error
+	ExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error)
+	Account(ctx context.Context) (*futures.Account, error)
+	Positions(ctx context.Context) ([]*futures.AccountPosition, error)
+	OpenOrders(ctx context.Context) ([]*futures.Order, error)
+	Depth(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error)
+	Klines(ctx context.Context, symbol, interval string, limit int) ([]*futures.Kline, error)
+	// 全部合约24小时行情
+	Tickers(ctx context.Context) ([]*futures.PriceChangeStats, error)
+	CreateOrder(ctx context.Context, req OrderRequest) (*futures.CreateOrderResponse, error)
+	CancelOrder(ctx context.Context, symbol string, orderID int64) error
+	// 修改限价单的价格和数量
+	ModifyOrder(ctx context.Context, symbol string, orderID int64, side futures.SideType, quantity, price string) error
+}
+
+// 下单参数 为空的字段不发送
+type OrderRequest struct {
+	Symbol        string
+	Side          futures.SideType
+	PositionSide  futures.PositionSideType
+	Type          futures.OrderType
+	TimeInForce   futures.TimeInForceType
+	Quantity      string
+	Price         string
+	StopPrice     string // 条件单触发价
+	ClosePosition bool   // 条件单触发时平掉全部持仓 不带数量
+	ReduceOnly    bool   // 只减仓 单向持仓模式使用
+
+	ActivationPrice string // 跟踪止损激活价
+	CallbackRate    string // 跟踪止损回调比例 %
+}
+
+// 当前使用的交易所
+var exchange Exchange
+
+// 持仓有本地缓存的交易所 可以绕过缓存读取
+type restPositioner interface {
+	RESTPositions(ctx context.Context) ([]*futures.AccountPosition, error)
+}
+
+// 交易所当前的持仓 不读本地缓存 用于下单后立即确认
+func restPositions(ctx context.Context) ([]*futures.AccountPosition, error) {
+	if ex, ok := exchange.(restPositioner); ok {
+		return ex.RESTPositions(ctx)
+	}
+	return exchange.Positions(ctx)
+}
+
+// Binance U本位合约
+type binanceExchange struct {
+	client *futures.Client
+}
+
+func newBinanceExchange(client *futures.Client) *binanceExchange {
+	return &binanceExchange{client: client}
+}
+
+func (b *binanceExchange) SyncTime(ctx context.Context) error {
+	_, err := b.client.NewSetServerTimeService().Do(ctx)
+	return err
+}
+
+func (b *binanceExchange) ExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error) {
+	return b.client.NewExchangeInfoService().Do(ctx)
+}
+
+func (b *binanceExchange) Account(ctx context.Context) (*futures.Account, error) {
+	return b.client.NewGetAccountService().Do(ctx)
+}
+
+func (b *binanceExchange) Positions(ctx context.Context) ([]*futures.AccountPosition, error) {
+	account, err := b.Account(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return account.Positions, nil
+}
+
+func (b *binanceExchange) OpenOrders(ctx context.Context) ([]*futures.Order, error) {
+	return b.client.NewListOpenOrdersService().Do(ctx)
+}
+
+func (b *binanceExchange) Depth(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error) {
+	return b.client.NewDepthService().Symbol(symbol).Limit(limit).Do(ctx)
+}
+
+func (b *binanceExchange) Klines(ctx context.Context, symbol, interval string, limit int) ([]*futures.Kline, error) {
+	return b.client.NewKlinesService().Symbol(symbol).Interval(interval).Limit(limit).Do(ctx)
+}
+
+func (b *binanceExchange) Tickers(ctx context.Context) ([]*futures.PriceChangeStats, error) {
+	return b.client.NewListPriceChangeStatsService().Do(ctx)
+}
+
+func (b *binanceExchange) CreateOrder(ctx context.Context, req OrderRequest) (*futures.CreateOrderResponse, error) {
+	service := b.client.NewCreateOrderService().Symbol(req.Symbol).Type(req.Type).
+		Side(req.Side).PositionSide(req.PositionSide)
+	if req.Quantity != "" {
+		service = service.Quantity(req.Quantity)
+	}
+	if req.Price != "" {
+		service = service.Price(req.Price)
+	}
+	if req.TimeInForce != "" {
+		service = service.TimeInForce(req.TimeInForce)
+	}
+	if req.StopPrice != "" {
+		service = service.StopPrice(req.StopPrice)
+	}
+	if req.ClosePosition {
+		service = service.ClosePosition(true)
+	}
+	if req.ReduceOnly {
+		service = service.ReduceOnly(true)
+	}
+	if req.ActivationPrice != "" {
+		service = service.ActivationPrice(req.ActivationPrice)
+	}
+	if req.CallbackRate != "" {
+		service = service.CallbackRate(req.CallbackRate)
+	}
+	return service.Do(ctx)
+}
+
+func (b *binanceExchange) CancelOrder(ctx context.Context, symbol string, orderID int64) error {
+	_, err := b.client.NewCancelOrderService().Symbol(symbol).OrderID(orderID).Do(ctx)
+	return err
+}
+
+func (b *binanceExchange) ModifyOrder(ctx context.Context, symbol string, orderID int64, side futures.SideType, quantity, price string) error {
+	_, err := b.client.NewModifyOrderService().Symbol(symbol).OrderID(orderID).Side(side).
+		Quantity(quantity).Price(price).Do(ctx)
+	return err
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 资金流窗口
+const (
+	flowWindowM5  = 5 * 60
+	flowWindowM15 = 15 * 60
+	flowWindowM30 = 30 * 60
+	flowWindowH1  = 60 * 60
+	flowWindowH4  = 4 * 60 * 60
+	flowWindowD1  = 24 * 60 * 60
+)
+
+// 单连接订阅数量
+const flowStreamsPerConn = 100
+
+// 单币种滚动资金流 1 小时内按秒分桶，24 小时内按分钟分桶
+type flowRing struct {
+	net         [flowWindowH1]float64
+	stamp       [flowWindowH1]int64
+	minuteNet   [flowWindowD1 / 60]float64
+	minuteStamp [flowWindowD1 / 60]int64
+}
+
+func (r *flowRing) add(sec int64, net float64) {
+	// 晚到的成交所在的桶已被更新的时间占用时丢弃
+	i := sec % flowWindowH1
+	if r.stamp[i] < sec {
+		r.stamp[i] = sec
+		r.net[i] = 0
+	}
+	if r.stamp[i] == sec {
+		r.net[i] += net
+	}
+
+	minute := sec / 60
+	j := minute % int64(len(r.minuteStamp))
+	if r.minuteStamp[j] < minute {
+		r.minuteStamp[j] = minute
+		r.minuteNet[j] = 0
+	}
+	if r.minuteStamp[j] == minute {
+		r.minuteNet[j] += net
+	}
+}
+
+// 取 (now-window, now] 内的净流入 超过 1 小时的窗口精度为分钟
+func (r *flowRing) sum(now int64, window int64) float64 {
+	var total float64
+	if window <= flowWindowH1 {
+		for i := range r.stamp {
+			if r.stamp[i] > now-window && r.stamp[i] <= now {
+				total += r.net[i]
+			}
+		}
+		return total
+	}
+	nowMinute := now / 60
+	for i := range r.minuteStamp {
+		if r.minuteStamp[i] > nowMinute-window/60 && r.minuteStamp[i] <= nowMinute {
+			total += r.minuteNet[i]
+		}
+	}
+	return total
+}
+
+// 主动成交资金流聚合
+type flowAggregator struct {
+	mu    sync.Mutex
+	rings map[string]*flowRing
+}
+
+func newFlowAggregator() *flowAggregator {
+	return &flowAggregator{rings: make(map[string]*flowRing)}
+}
+
+// 累加一笔归集成交 买方为挂单方时为主动卖出（流出）
+func (a *flowAggregator) Add(event *futures.WsAggTradeEvent) error {
+	price, err := strconv.ParseFloat(event.Price, 64)
+	if err != nil {
+		return err
+	}
+	quantity, err := strconv.ParseFloat(event.Quantity, 64)
+	if err != nil {
+		return err
+	}
+	net := price * quantity
+	if event.Maker {
+		net = -net
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ring, ok := a.rings[event.Symbol]
+	if !ok {
+		ring = &flowRing{}
+		a.rings[event.Symbol] = ring
+	}
+	ring.add(event.TradeTime/1000, net)
+	return nil
+}
+
+// 生成 now 时刻的资金流快照
+func (a *flowAggregator) Snapshot(now time.Time) []FundData {
+	sec := now.Unix()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	data := make([]FundData, 0, len(a.rings))
+	for symbol, ring := range a.rings {
+		coin := coinOf(symbol)
+		if !contains(symbolsString, coin) {
+			continue
+		}
+		m5Net := ring.sum(sec, flowWindowM5)
+		data = append(data, FundData{
+			Coin:   coin,
+			M5Net:  m5Net,
+			M15Net: ring.sum(sec, flowWindowM15),
+			M30Net: ring.sum(sec, flowWindowM30),
+			H1Net:  ring.sum(sec, flowWindowH1),
+			H4Net:  ring.sum(sec, flowWindowH4),
+			D1Net:  ring.sum(sec, flowWindowD1),
+		})
+	}
+	return data
+}
+
+// Binance aggTrade 数据源
+type binanceFlowSource struct {
+	agg     *flowAggregator
+	started time.Time
+	mu      sync.Mutex // 保护 file 与 record
+	file    *os.File
+	record  *json.Encoder
+}
+
+func newBinanceFlowSource(recordFile string) (*binanceFlowSource, error) {
+	s := &binanceFlowSource{agg: newFlowAggregator(), started: time.Now()}
+	if recordFile != "" {
+		file, err := os.OpenFile(recordFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return nil, err
+		}
+		s.file = file
+		s.record = json.NewEncoder(file)
+	}
+	return s, nil
+}
+
+// 关闭录制文件
+func (s *binanceFlowSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	s.record = nil
+	return err
+}
+
+// 订阅全部可交易币种 断线自动重连
+func (s *binanceFlowSource) Start(symbolNames []string) {
+	for i := 0; i < len(symbolNames); i += flowStreamsPerConn {
+		end := i + flowStreamsPerConn
+		if end > len(symbolNames) {
+			end = len(symbolNames)
+		}
+		go s.serve(symbolNames[i:end])
+	}
+}
+
+func (s *binanceFlowSource) serve(symbolNames []string) {
+	for {
+		doneC, _, err := futures.WsCombinedAggTradeServe(symbolNames, s.handle, func(err error) {
+			log.Println("[aggTrade]", err)
+		})
+		if err != nil {
+			log.Println("[aggTrade]", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		<-doneC
+		log.Println("[aggTrade] 断开 重连")
+		time.Sleep(time.Second)
+	}
+}
+
+func (s *binanceFlowSource) handle(event *futures.WsAggTradeEvent) {
+	if err := s.agg.Add(event); err != nil {
+		log.Println("[aggTrade]", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.record != nil {
+		if err := s.record.Encode(event); err != nil {
+			log.Println("[aggTrade]", err)
+		}
+	}
+}
+
+func (s *binanceFlowSource) Fetch() ([]FundData, error) {
+	// 15 分钟窗口填满前数据不完整
+	if warm := time.Since(s.started); warm < flowWindowM15*time.Second {
+		return nil, fmt.Errorf("[aggTrade] 预热中 %s/%s", warm.Truncate(time.Second), flowWindowM15*time.Second)
+	}
+	return s.agg.Snapshot(time.Now()), nil
+}
+
+// 录制的 aggTrade 文件数据源 每行一个 WsAggTradeEvent
+// 第一次取数据时回放 15 分钟预热 之后每次向后回放 step
+type aggTradeFileSource struct {
+	path    string
+	step    time.Duration
+	mu      sync.Mutex
+	file    *os.File
+	scanner *bufio.Scanner
+	agg     *flowAggregator
+	pending *futures.WsAggTradeEvent // 已读出但属于下一份快照的成交
+	until   int64                    // 当前快照时间 ms
+}
+
+func newAggTradeFileSource(path string, step time.Duration) *aggTradeFileSource {
+	if step <= 0 {
+		step = time.Minute
+	}
+	return &aggTradeFileSource{path: path, step: step}
+}
+
+func (s *aggTradeFileSource) Fetch() ([]FundData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.agg == nil {
+		file, err := os.Open(s.path)
+		if err != nil {
+			return nil, err
+		}
+		s.file = file
+		s.scanner = bufio.NewScanner(file)
+		s.agg = newFlowAggregator()
+	}
+	if s.pending == nil {
+		event, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		s.pending = event
+	}
+	if s.until == 0 {
+		s.until = s.pending.TradeTime + flowWindowM15*1000
+	} else {
+		s.until += s.step.Milliseconds()
+	}
+	for s.pending != nil && s.pending.TradeTime <= s.until {
+		if err := s.agg.Add(s.pending); err != nil {
+			return nil, err
+		}
+		event, err := s.next()
+		if err == io.EOF {
+			s.pending = nil
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.pending = event
+	}
+	return s.agg.Snapshot(time.UnixMilli(s.until)), nil
+}
+
+// 读下一笔成交 文件读完时返回 io.EOF
+func (s *aggTradeFileSource) next() (*futures.WsAggTradeEvent, error) {
+	for s.scanner.Scan() {
+		line := s.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		event := new(futures.WsAggTradeEvent)
+		if err := json.Unmarshal(line, event); err != nil {
+			return nil, err
+		}
+		return event, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (s *aggTradeFileSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
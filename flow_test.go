@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 按币种取快照
+func flowByCoin(data []FundData) map[string]FundData {
+	m := make(map[string]FundData, len(data))
+	for _, d := range data {
+		m[d.Coin] = d
+	}
+	return m
+}
+
+func TestAggTradeFileSource(t *testing.T) {
+	saved := symbolsString
+	defer func() { symbolsString = saved }()
+	symbolsString = []string{"BTC", "ETH"}
+
+	source := newAggTradeFileSource(filepath.Join("testdata", "aggtrades.jsonl"), time.Minute)
+	defer source.Close()
+
+	// 第一份快照在第一笔成交后 15 分钟 窗口不含起点
+	data, err := source.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows := flowByCoin(data)
+	if btc := flows["BTC"]; btc.M5Net != 200 || btc.M15Net != 200 || btc.H1Net != 300 {
+		t.Errorf("BTC first snapshot = %+v", btc)
+	}
+	if eth := flows["ETH"]; eth.M5Net != 0 || eth.M15Net != -20 || eth.H1Net != -20 {
+		t.Errorf("ETH first snapshot = %+v", eth)
+	}
+
+	// 之后每次向后回放一分钟
+	data, err = source.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows = flowByCoin(data)
+	if btc := flows["BTC"]; btc.M5Net != 100 || btc.M15Net != 100 || btc.H1Net != 200 {
+		t.Errorf("BTC second snapshot = %+v", btc)
+	}
+
+	if _, err := source.Fetch(); err != io.EOF {
+		t.Fatalf("Fetch after last trade: %v", err)
+	}
+}
+
+func TestFlowRingDropsLateEvents(t *testing.T) {
+	var ring flowRing
+	ring.add(4560, 100)
+	// 同一个秒桶已被一小时后的成交占用
+	ring.add(960, 50)
+	if got := ring.sum(4560, flowWindowH1); got != 100 {
+		t.Errorf("H1 sum = %v, want 100", got)
+	}
+	if got := ring.sum(4560, flowWindowM5); got != 100 {
+		t.Errorf("M5 sum = %v, want 100", got)
+	}
+	// 桶未被占用的晚到成交照常计入
+	ring.add(4500, 10)
+	if got := ring.sum(4560, flowWindowM5); got != 110 {
+		t.Errorf("M5 sum after late event = %v, want 110", got)
+	}
+}
+
+func TestBinanceFlowSourceRecord(t *testing.T) {
+	saved := symbolsString
+	defer func() { symbolsString = saved }()
+	symbolsString = []string{"BTC"}
+
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+	source, err := newBinanceFlowSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.handle(&futures.WsAggTradeEvent{Symbol: "BTCUSDT", Price: "100", Quantity: "3", TradeTime: 1700000000000})
+	source.handle(&futures.WsAggTradeEvent{Symbol: "BTCUSDT", Price: "100", Quantity: "1", TradeTime: 1700000060000, Maker: true})
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 录制文件可离线回放出同样的资金流
+	replay := newAggTradeFileSource(path, time.Minute)
+	defer replay.Close()
+	data, err := replay.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if btc := flowByCoin(data)["BTC"]; btc.H1Net != 200 {
+		t.Errorf("replayed BTC = %+v", btc)
+	}
+}
+
+func TestBinanceFlowSourceHandleAfterClose(t *testing.T) {
+	saved := symbolsString
+	defer func() { symbolsString = saved }()
+	symbolsString = []string{"BTC"}
+
+	source, err := newBinanceFlowSource(filepath.Join(t.TempDir(), "record.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			source.handle(&futures.WsAggTradeEvent{Symbol: "BTCUSDT", Price: "100", Quantity: "1", TradeTime: 1700000000000 + int64(i)})
+		}
+	}()
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 默认历史长度与最少样本数
+const (
+	historyDefaultSize       = 1440
+	historyDefaultMinSamples = 30
+)
+
+// 单次资金流快照
+type flowSnapshot struct {
+	Time   time.Time
+	M5Net  float64
+	M15Net float64
+}
+
+// 按币种滚动保存资金流快照
+type flowHistory struct {
+	mu   sync.Mutex
+	size int
+	data map[string][]flowSnapshot
+}
+
+var fundHistory = newFlowHistory(historyDefaultSize)
+
+func newFlowHistory(size int) *flowHistory {
+	if size <= 0 {
+		size = historyDefaultSize
+	}
+	return &flowHistory{size: size, data: make(map[string][]flowSnapshot)}
+}
+
+// 记录一轮快照
+func (h *flowHistory) Record(data []FundData, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range data {
+		snapshots := append(h.data[s.Coin], flowSnapshot{Time: now, M5Net: s.M5Net, M15Net: s.M15Net})
+		if len(snapshots) > h.size {
+			snapshots = snapshots[len(snapshots)-h.size:]
+		}
+		h.data[s.Coin] = snapshots
+	}
+}
+
+// 快照中的资金流周期
+type flowField func(s flowSnapshot) float64
+
+func snapshotM5(s flowSnapshot) float64  { return s.M5Net }
+func snapshotM15(s flowSnapshot) float64 { return s.M15Net }
+
+// 取出某币种某周期的历史序列
+func (h *flowHistory) values(coin string, field flowField) []float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snapshots := h.data[coin]
+	values := make([]float64, len(snapshots))
+	for i, s := range snapshots {
+		values[i] = field(s)
+	}
+	return values
+}
+
+// 样本数是否足够
+func historyEnough(values []float64) bool {
+	minSamples := config.HistoryMinSamples
+	if minSamples <= 0 {
+		minSamples = historyDefaultMinSamples
+	}
+	return len(values) >= minSamples
+}
+
+// value 相对该币种该周期历史分布的 z-score
+func (h *flowHistory) ZScore(coin string, field flowField, value float64) (float64, bool) {
+	values := h.values(coin, field)
+	if !historyEnough(values) {
+		return 0, false
+	}
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	std := math.Sqrt(variance / float64(len(values)))
+	if std == 0 {
+		return 0, false
+	}
+	return (value - mean) / std, true
+}
+
+// value 在该币种该周期历史分布中的百分位 0-100
+func (h *flowHistory) Percentile(coin string, field flowField, value float64) (float64, bool) {
+	values := h.values(coin, field)
+	if !historyEnough(values) {
+		return 0, false
+	}
+	sort.Float64s(values)
+	below := sort.SearchFloat64s(values, value)
+	return float64(below) / float64(len(values)) * 100, true
+}
+
+// 填充24小时成交额
+func fillQuoteVolume(data []FundData) error {
+	stats, err := exchange.Tickers(context.Background())
+	if err != nil {
+		return err
+	}
+	volumes := make(map[string]float64, len(stats))
+	for _, s := range stats {
+		coin, ok := symbolCoins[s.Symbol]
+		if !ok {
+			continue
+		}
+		volume, err := strconv.ParseFloat(s.QuoteVolume, 64)
+		if err != nil {
+			continue
+		}
+		volumes[coin] = volume
+	}
+	for i := range data {
+		data[i].QuoteVolume = volumes[data[i].Coin]
+	}
+	return nil
+}
+
+// 资金流阈值判断 ThresholdMode: absolute/zscore/percentile/volume
+// zscore/percentile 模式 M5Net 与 M15Net 都须相对各自的历史分布达标
+func netTrigger(s FundData, long bool) bool {
+	switch config.ThresholdMode {
+	case "zscore":
+		return zScoreTrigger(s.Coin, snapshotM5, s.M5Net, long) && zScoreTrigger(s.Coin, snapshotM15, s.M15Net, long)
+	case "percentile":
+		return percentileTrigger(s.Coin, snapshotM5, s.M5Net, long) && percentileTrigger(s.Coin, snapshotM15, s.M15Net, long)
+	case "volume":
+		if s.QuoteVolume <= 0 {
+			return false
+		}
+		ratio := s.M5Net / s.QuoteVolume
+		if long {
+			return ratio >= config.VolumeNetRatio
+		}
+		return ratio <= -config.VolumeNetRatio
+	default:
+		if long {
+			return s.M5Net > config.BuyNetAmount
+		}
+		return s.M5Net < -config.SideNetAmount
+	}
+}
+
+func zScoreTrigger(coin string, field flowField, value float64, long bool) bool {
+	z, ok := fundHistory.ZScore(coin, field, value)
+	if !ok {
+		return false
+	}
+	if long {
+		return z >= config.ZScoreLevel
+	}
+	return z <= -config.ZScoreLevel
+}
+
+func percentileTrigger(coin string, field flowField, value float64, long bool) bool {
+	pct, ok := fundHistory.Percentile(coin, field, value)
+	if !ok {
+		return false
+	}
+	if long {
+		return pct >= config.PercentileLevel
+	}
+	return pct <= 100-config.PercentileLevel
+}
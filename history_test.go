@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNetTriggerUsesM15History(t *testing.T) {
+	savedConfig, savedHistory := config, fundHistory
+	defer func() { config, fundHistory = savedConfig, savedHistory }()
+	config = Config{ThresholdMode: "zscore", ZScoreLevel: 2, HistoryMinSamples: 10}
+	fundHistory = newFlowHistory(100)
+
+	// M5Net 在 ±1万 之间波动 M15Net 在 ±100万 之间波动
+	now := time.Unix(0, 0)
+	for i := 0; i < 20; i++ {
+		sign := float64(1 - 2*(i%2))
+		fundHistory.Record([]FundData{{Coin: "AAA", M5Net: sign * 10000, M15Net: sign * 1000000}}, now)
+		now = now.Add(time.Minute)
+	}
+
+	// M5Net 与 M15Net 都远超各自的历史波动
+	if !netTrigger(FundData{Coin: "AAA", M5Net: 50000, M15Net: 5000000}, true) {
+		t.Error("expected long trigger")
+	}
+	// M15Net 相对自身历史并不突出
+	if netTrigger(FundData{Coin: "AAA", M5Net: 50000, M15Net: 500000}, true) {
+		t.Error("M15Net within its own distribution should not trigger")
+	}
+	if !netTrigger(FundData{Coin: "AAA", M5Net: -50000, M15Net: -5000000}, false) {
+		t.Error("expected short trigger")
+	}
+
+	config.ThresholdMode = "percentile"
+	config.PercentileLevel = 90
+	if !netTrigger(FundData{Coin: "AAA", M5Net: 50000, M15Net: 5000000}, true) {
+		t.Error("expected percentile long trigger")
+	}
+	if netTrigger(FundData{Coin: "AAA", M5Net: 50000, M15Net: 0}, true) {
+		t.Error("M15Net percentile below level should not trigger")
+	}
+}
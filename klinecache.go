@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// K线缓存参数
+const (
+	klineCacheSize       = 500              // 每个合约周期至少缓存的根数
+	klineRestMax         = 1500             // REST 单次最多根数
+	klineSeriesIdle      = 30 * time.Minute // 超过该时间没有读取的停止订阅
+	klineSeriesRetry     = 3 * time.Second
+	klineIdleInterval    = time.Minute
+	klineFetchConcurrent = 8 // 并发拉取K线的合约数
+)
+
+// K线不连续
+var errKlineGap = errors.New("K线不连续")
+
+// 合约与周期
+type klineKey struct {
+	Symbol   string
+	Interval string
+}
+
+// K线周期时长 月线不固定返回 false
+func klineIntervalDuration(interval string) (time.Duration, bool) {
+	if len(interval) < 2 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(interval[:len(interval)-1])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	switch interval[len(interval)-1] {
+	case 'm':
+		return time.Duration(n) * time.Minute, true
+	case 'h':
+		return time.Duration(n) * time.Hour, true
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, true
+	case 'w':
+		return time.Duration(n) * 7 * 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// 单个合约周期的K线 REST 回填后由K线流更新 最后一根为未收盘的当前K线
+type klineSeries struct {
+	key    klineKey
+	period time.Duration
+	size   int
+
+	mu       sync.Mutex
+	synced   bool
+	klines   []*futures.Kline
+	lastRead time.Time
+
+	stop chan struct{}
+}
+
+func newKlineSeries(key klineKey, period time.Duration, size int) *klineSeries {
+	return &klineSeries{key: key, period: period, size: size, lastRead: time.Now(), stop: make(chan struct{})}
+}
+
+// 订阅并维护K线 断线或不连续时重新回填
+func (k *klineSeries) run(rest Exchange) {
+	for {
+		err := k.serve(rest)
+		k.mu.Lock()
+		k.synced = false
+		k.mu.Unlock()
+		select {
+		case <-k.stop:
+			return
+		default:
+		}
+		if err != nil {
+			log.Println(k.key.Symbol, k.key.Interval, "[KLINE]", err, "重建")
+		}
+		time.Sleep(klineSeriesRetry)
+	}
+}
+
+func (k *klineSeries) serve(rest Exchange) error {
+	events := make(chan *futures.WsKlineEvent, 64)
+	// 丢掉的可能是一根K线的最后一次推送 之后的推送开了新K线 不会被当作缺口 只能重新回填
+	overflow := make(chan struct{}, 1)
+	doneC, stopC, err := futures.WsKlineServe(k.key.Symbol, k.key.Interval, func(event *futures.WsKlineEvent) {
+		select {
+		case events <- event:
+		default:
+			select {
+			case overflow <- struct{}{}:
+			default:
+			}
+		}
+	}, func(err error) {
+		log.Println(k.key.Symbol, k.key.Interval, "[KLINE]", err)
+	})
+	if err != nil {
+		return err
+	}
+	defer close(stopC)
+	// 先订阅再回填 回填之前的推送按开盘时间合并
+	if err := k.backfill(rest, k.size); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-k.stop:
+			return nil
+		case <-doneC:
+			return fmt.Errorf("断开")
+		case <-overflow:
+			return fmt.Errorf("推送积压")
+		case event := <-events:
+			if err := k.apply(&event.Kline); err == errKlineGap {
+				// 补齐缺口 缺得太多时整段重新回填
+				if err := k.repair(rest); err != nil {
+					return err
+				}
+				k.apply(&event.Kline)
+			}
+		}
+	}
+}
+
+// REST 拉取最近 limit 根 与已有的按开盘时间合并
+func (k *klineSeries) backfill(rest Exchange, limit int) error {
+	if limit > klineRestMax {
+		limit = klineRestMax
+	}
+	klines, err := rest.Klines(context.Background(), k.key.Symbol, k.key.Interval, limit)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.merge(klines)
+	k.synced = true
+	return nil
+}
+
+// 从最后一根开始补齐到当前
+func (k *klineSeries) repair(rest Exchange) error {
+	k.mu.Lock()
+	limit := k.size
+	if n := len(k.klines); n > 0 && k.period > 0 {
+		limit = int(time.Since(time.UnixMilli(k.klines[n-1].OpenTime))/k.period) + 2
+	}
+	k.mu.Unlock()
+	if limit > klineRestMax {
+		// 缺口超过单次上限 丢弃旧数据重新回填
+		k.mu.Lock()
+		k.klines = nil
+		k.mu.Unlock()
+		limit = k.size
+	}
+	log.Println(k.key.Symbol, k.key.Interval, "[KLINE] 补齐缺口", limit, "根")
+	return k.backfill(rest, limit)
+}
+
+// 按开盘时间合并 相同开盘时间以新数据为准 只保留最近 size 根
+func (k *klineSeries) merge(klines []*futures.Kline) {
+	byTime := make(map[int64]*futures.Kline, len(k.klines)+len(klines))
+	for _, kline := range k.klines {
+		byTime[kline.OpenTime] = kline
+	}
+	for _, kline := range klines {
+		byTime[kline.OpenTime] = kline
+	}
+	merged := make([]*futures.Kline, 0, len(byTime))
+	for _, kline := range byTime {
+		merged = append(merged, kline)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].OpenTime < merged[j].OpenTime })
+	if len(merged) > k.size {
+		merged = merged[len(merged)-k.size:]
+	}
+	k.klines = merged
+}
+
+// 应用推送 更新当前K线或追加新K线 与最后一根之间缺K线时返回 errKlineGap
+func (k *klineSeries) apply(w *futures.WsKline) error {
+	kline := &futures.Kline{
+		OpenTime:                 w.StartTime,
+		Open:                     w.Open,
+		High:                     w.High,
+		Low:                      w.Low,
+		Close:                    w.Close,
+		Volume:                   w.Volume,
+		CloseTime:                w.EndTime,
+		QuoteAssetVolume:         w.QuoteVolume,
+		TradeNum:                 w.TradeNum,
+		TakerBuyBaseAssetVolume:  w.ActiveBuyVolume,
+		TakerBuyQuoteAssetVolume: w.ActiveBuyQuoteVolume,
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	n := len(k.klines)
+	if n == 0 {
+		k.klines = append(k.klines, kline)
+		return nil
+	}
+	last := k.klines[n-1]
+	switch {
+	case kline.OpenTime == last.OpenTime:
+		k.klines[n-1] = kline
+	case kline.OpenTime < last.OpenTime:
+		// 迟到的推送
+	case k.period > 0 && kline.OpenTime-last.OpenTime > k.period.Milliseconds():
+		return errKlineGap
+	default:
+		k.klines = append(k.klines, kline)
+		if len(k.klines) > k.size {
+			k.klines = k.klines[len(k.klines)-k.size:]
+		}
+	}
+	return nil
+}
+
+// 最近 limit 根 含当前未收盘的一根 未同步、根数不够或已落后一个周期以上时返回 false
+func (k *klineSeries) get(limit int) ([]*futures.Kline, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lastRead = time.Now()
+	n := len(k.klines)
+	if !k.synced || n < limit {
+		return nil, false
+	}
+	if k.period > 0 && time.Since(time.UnixMilli(k.klines[n-1].CloseTime)) > k.period {
+		return nil, false
+	}
+	res := make([]*futures.Kline, 0, limit)
+	for _, kline := range k.klines[n-limit:] {
+		copied := *kline
+		res = append(res, &copied)
+	}
+	return res, true
+}
+
+// K线优先读缓存 第一次读取某个合约周期时回填并订阅 缓存不可用时走 REST
+type klineCacheExchange struct {
+	Exchange
+
+	mu     sync.Mutex
+	series map[klineKey]*klineSeries
+}
+
+func newKlineCacheExchange(live Exchange) *klineCacheExchange {
+	c := &klineCacheExchange{Exchange: live, series: make(map[klineKey]*klineSeries)}
+	go c.expire()
+	return c
+}
+
+func (c *klineCacheExchange) Klines(ctx context.Context, symbol, interval string, limit int) ([]*futures.Kline, error) {
+	key := klineKey{symbol, interval}
+	c.mu.Lock()
+	series, watched := c.series[key]
+	// 需要的根数超过已缓存的上限时重建
+	if watched && limit > series.size {
+		close(series.stop)
+		watched = false
+	}
+	if !watched {
+		size := klineCacheSize
+		if limit > size {
+			size = limit
+		}
+		period, _ := klineIntervalDuration(interval)
+		series = newKlineSeries(key, period, size)
+		c.series[key] = series
+		go series.run(c.Exchange)
+	}
+	c.mu.Unlock()
+	if klines, ok := series.get(limit); ok {
+		return klines, nil
+	}
+	return c.Exchange.Klines(ctx, symbol, interval, limit)
+}
+
+// 停止长时间没有读取的K线
+func (c *klineCacheExchange) expire() {
+	for {
+		time.Sleep(klineIdleInterval)
+		c.mu.Lock()
+		for key, series := range c.series {
+			series.mu.Lock()
+			idle := time.Since(series.lastRead) > klineSeriesIdle
+			series.mu.Unlock()
+			if idle {
+				close(series.stop)
+				delete(c.series, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// 已收盘的最近 limit 根K线 去掉未收盘的当前K线
+func closedKlines(ctx context.Context, symbol, interval string, limit int) ([]*futures.Kline, error) {
+	klines, err := exchange.Klines(ctx, symbol, interval, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(klines); n > 0 && klines[n-1].CloseTime >= clock().UnixMilli() {
+		klines = klines[:n-1]
+	}
+	if len(klines) > limit {
+		klines = klines[len(klines)-limit:]
+	}
+	return klines, nil
+}
+
+// 并发拉取多个合约的K线 结果与 symbols 顺序一致
+func fetchKlines(symbols []string, interval string, limit int) ([][]*futures.Kline, []error) {
+	klines := make([][]*futures.Kline, len(symbols))
+	errs := make([]error, len(symbols))
+	sem := make(chan struct{}, klineFetchConcurrent)
+	var wg sync.WaitGroup
+	for i, symbol := range symbols {
+		wg.Add(1)
+		go func(i int, symbol string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			klines[i], errs[i] = exchange.Klines(context.Background(), symbol, interval, limit)
+		}(i, symbol)
+	}
+	wg.Wait()
+	return klines, errs
+}
@@ -3,18 +3,19 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
 	"sort"
 	"strconv"
-	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
@@ -26,8 +27,23 @@ type FundData struct {
 	Side   bool
 	M5Net  float64 `json:"m5net"`
 	M15Net float64 `json:"m15net"`
+	M30Net float64 `json:"m30net"`
+	H1Net  float64 `json:"h1net"`
+	H4Net  float64 `json:"h4net"`
+	D1Net  float64 `json:"d1net"`
+
+	SpotMissing bool    `json:"spotMissing,omitempty"` // 本轮没取到该币种的现货资金流
+	SpotM5Net   float64 `json:"spotM5net"`             // 现货 5分钟净流入
+	SpotM15Net  float64 `json:"spotM15net"`            // 现货 15分钟净流入
+
+	QuoteVolume float64 `json:"quoteVolume"` // 24小时成交额
+
+	Signal string `json:"signal,omitempty"` // 触发的信号类型 VOL/DIV/RSI
 }
 
+// 平仓确认的最多次数
+const closePositionAttempts = 3
+
 // 全局客户端
 var client *futures.Client
 
@@ -39,7 +55,11 @@ var httpClient *http.Client
 
 var infoData *futures.ExchangeInfo
 
+// 当前时间 回测时替换为模拟时间
+var clock = time.Now
+
 func main() {
+	loadConfig()
 	fmt.Printf("Go version: %s\n", runtime.Version())
 
 	// / 获取当前日期，按日期生成日志文件名
@@ -100,10 +120,30 @@ func main() {
 	}
 
 	client.HTTPClient = httpClient
-	if checkConnection("https://coinank.com/api/fund/fundReal?page=1&size=50&type=1&productType=SWAP&sortBy=&baseCoin=&isFollow=false") {
-		fmt.Println("Coinank OK")
-	} else {
-		log.Fatal("Connection failed Coinank")
+	exchange = newBinanceExchange(client)
+	coinankClient = NewCoinankClient(httpClient)
+
+	// 回测
+	if len(os.Args) > 1 && os.Args[1] == "backtest" {
+		if err := runBacktest(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// 参数优化
+	if len(os.Args) > 1 && os.Args[1] == "optimize" {
+		if err := runOptimize(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// 其他数据源不依赖 Coinank
+	if sourceNeedsCoinank(config.Source) {
+		if checkConnection(coinankQueryFromConfig().URL()) {
+			fmt.Println("Coinank OK")
+		} else {
+			log.Fatal("Connection failed Coinank")
+		}
 	}
 	if checkConnection("https://fapi.binance.com/fapi/v1/time") {
 		fmt.Println("Binance OK")
@@ -112,24 +152,57 @@ func main() {
 	}
 
 	// 时间偏移
-	_, err = client.NewSetServerTimeService().Do(context.Background())
+	err = exchange.SyncTime(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
 	// 获取交易信息
-	info, err := client.NewExchangeInfoService().Do(context.Background())
+	info, err := exchange.ExchangeInfo(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
-	infoData = info
-	// 赛选币种
-	for _, s := range info.Symbols {
-		if s.QuoteAsset == "USDT" && s.ContractType == "PERPETUAL" && s.Status == "TRADING" && !contains(config.Blacklist, s.BaseAsset) {
-			symbols = append(symbols, s)
-			symbolsString = append(symbolsString, s.BaseAsset)
+	if err := setupSymbols(info); err != nil {
+		log.Fatal(err)
+	}
+	// K线缓存 REST 回填后由K线流更新
+	if config.KlineStream {
+		exchange = newKlineCacheExchange(exchange)
+	}
+	// 本地订单簿 下单前不再请求 REST 深度
+	if config.DepthStream {
+		exchange = newDepthBookExchange(exchange)
+	}
+	// 用户数据流 实时更新挂单与成交
+	if config.UserStream && config.Mode != "paper" {
+		stream := newUserStreamExchange(exchange, client)
+		stream.Start()
+		exchange = stream
+	}
+	// 模拟盘
+	if config.Mode == "paper" {
+		paper, err := newPaperExchange(exchange, client, info, config.PaperFile, config.PaperBalance)
+		if err != nil {
+			log.Fatal(err)
 		}
+		exchange = paper
+		go paper.Run()
+		log.Println("[PAPER] 模拟盘模式")
 	}
 
+	if config.RecordDir != "" {
+		recorder, err = NewRecorder(config.RecordDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	fundHistory = newFlowHistory(config.HistorySize)
+	signalSource, err = newSignalSource(config.Source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 持仓管理
+	go runManager()
+
 	now := time.Now()
 	nextMinute := now.Truncate(time.Minute).Add(time.Minute)
 	duration := nextMinute.Sub(now)
@@ -153,62 +226,120 @@ func main() {
 		for {
 			// 时间偏移
 			time.Sleep(300 * time.Second)
-			_, err = client.NewSetServerTimeService().Do(context.Background())
+			err = exchange.SyncTime(context.Background())
 			if err != nil {
 				log.Fatal(err)
 			}
 		}
 	}()
-	//
-	select {}
+	// 退出时关闭数据源 写完录制文件
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	if closer, ok := signalSource.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// 筛选可交易币种并建立 Coinank 映射
+func setupSymbols(info *futures.ExchangeInfo) error {
+	infoData = info
+	symbols = nil
+	symbolsString = nil
+	// 赛选币种
+	for _, s := range info.Symbols {
+		if s.QuoteAsset == "USDT" && s.ContractType == "PERPETUAL" && s.Status == "TRADING" && !contains(config.Blacklist, s.BaseAsset) {
+			symbols = append(symbols, s)
+		}
+	}
+	// Coinank 币种映射
+	overrides, err := loadSymbolOverrides(config.SymbolMapFile)
+	if err != nil {
+		return err
+	}
+	buildSymbolMappings(symbols, overrides)
+	for _, s := range symbols {
+		if coin := coinOf(s.Symbol); !contains(config.Blacklist, coin) {
+			symbolsString = append(symbolsString, coin)
+		}
+	}
+	return nil
 }
 
 // 开始
 func CoinankGo() error {
+	// 每轮单独记录 上一轮未结束时互不影响
+	record := recorder.Begin(clock())
+	defer recorder.End(record)
 
-	coinank, err := fetchFundCoinankData()
+	coinank, err := fetchSignals(record)
 	if err != nil {
 		log.Println(err)
 		return nil
 	}
-	symbolsNet, err := getTopAndBottomM5Net(coinank)
+	record.SetFunds(coinank)
+	_, err = runStrategy(coinank, record)
 	if err != nil {
 		log.Println(err)
 		return nil
 	}
-	symbolsFilter, err := filterSymbols(symbolsNet)
+	return nil
+}
+
+// 执行一轮策略 返回本轮触发信号的币种 record 为本轮快照 nil 时不记录
+func runStrategy(coinank []FundData, record *cycleRecord) ([]FundData, error) {
+	// 本轮判断完成后再计入历史
+	defer fundHistory.Record(coinank, clock())
+	// 新成交的持仓挂上止损止盈 并移动止损
+	managePositions()
+	if config.ThresholdMode == "volume" || sideRulesNeedVolume() {
+		if err := fillQuoteVolume(coinank); err != nil {
+			return nil, err
+		}
+	}
+	symbolsNet, err := getTopAndBottomM5Net(coinank)
 	if err != nil {
-		log.Println(err)
-		return nil
+		return nil, err
+	}
+	// 选出高低后再判断多空 中性区间的币种跳过
+	symbolsNet = classifySides(symbolsNet)
+	symbolsFilter, err := filterSymbols(symbolsNet, record)
+	if err != nil {
+		return nil, err
 	}
 	// log.Println(symbolsFilter)
 	if len(symbolsFilter) > 0 {
 
-		OpenSymbols, err := ordersAccount(symbolsFilter)
+		OpenSymbols, err := ordersAccount(symbolsFilter, record)
 		if err != nil {
-			log.Println(err)
-			return nil
+			return symbolsFilter, err
 		}
 		// log.Panicln(OpenSymbols)
-		err = ordersOrders(OpenSymbols)
+		err = ordersOrders(OpenSymbols, symbolsFilter, record)
 		if err != nil {
-			log.Println(err)
-			return nil
+			return symbolsFilter, err
 		}
 	} else {
 		log.Println("----------")
+		// 没有信号时也要撤掉超时和信号已消失的挂单
+		if err := ordersOrders(nil, nil, record); err != nil {
+			return symbolsFilter, err
+		}
 	}
-	return nil
+	return symbolsFilter, nil
 }
 
 // 处理已有订单
-func ordersAccount(symbols []FundData) (OpenSymbols []FundData, err error) {
+func ordersAccount(symbols []FundData, record *cycleRecord) (OpenSymbols []FundData, err error) {
 	// 账户信息
-	account, err := client.NewGetAccountService().Do(context.Background())
+	account, err := exchange.Account(context.Background())
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
+	record.SetAccount(account)
 	// 账户的总钱包余额，表示可用余额。
 	totalWalletBalance, err := strconv.ParseFloat(account.TotalWalletBalance, 64)
 	if err != nil {
@@ -226,7 +357,7 @@ func ordersAccount(symbols []FundData) (OpenSymbols []FundData, err error) {
 	// 计算已用余额
 	usedBalance := totalPositionInitialMargin + totalOpenOrderInitialMargin
 	if usedBalance > totalWalletBalance*config.MarginUtilizationRate || totalWalletBalance == 0 {
-		return nil, fmt.Errorf("use more than 50%")
+		return nil, fmt.Errorf("use more than %.0f%%", config.MarginUtilizationRate*100)
 	}
 
 	for _, symbol := range symbols {
@@ -272,7 +403,7 @@ func ordersAccount(symbols []FundData) (OpenSymbols []FundData, err error) {
 				if asset2.PositionSide == "LONG" && !symbol.Side {
 					log.Println(symbol.Coin, "LONG->SHORT / ", asset2.PositionSide)
 					OpenSymbols = append(OpenSymbols, symbol)
-					err = placeOrder(symbol.Coin+"USDT", "SELL", "LONG", false)
+					err = closePosition(asset2)
 					if err != nil {
 						log.Println(err)
 						continue
@@ -280,7 +411,7 @@ func ordersAccount(symbols []FundData) (OpenSymbols []FundData, err error) {
 				} else if asset2.PositionSide == "SHORT" && symbol.Side {
 					log.Println(symbol.Coin, "SHORT->LONG / ", asset2.PositionSide)
 					OpenSymbols = append(OpenSymbols, symbol)
-					err = placeOrder(symbol.Coin+"USDT", "BUY", "SHORT", false)
+					err = closePosition(asset2)
 					if err != nil {
 						log.Println(err)
 						continue
@@ -302,23 +433,56 @@ func ordersAccount(symbols []FundData) (OpenSymbols []FundData, err error) {
 	return OpenSymbols, nil
 }
 
-// 处理挂单
-func ordersOrders(symbols []FundData) error {
+// 挂单处理 同一时间只运行一轮 各轮策略可能重叠 signalMissing 与 chaseOrigins 只在其中读写
+var ordersMu sync.Mutex
+
+// 处理挂单 symbols 为需要挂单的币种 signals 为本轮全部信号
+func ordersOrders(symbols, signals []FundData, record *cycleRecord) error {
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
 	// 挂单
-	openOrders, err := client.NewListOpenOrdersService().Do(context.Background())
+	openOrders, err := exchange.OpenOrders(context.Background())
 	if err != nil {
 		log.Println(err)
 		return err
 	}
+	pruneOrderState(openOrders)
 	// 收集已取消的挂单
 	// 取消超时订单
 	for i, order := range openOrders {
 		// 只有开单才处理
-		if order.ClosePosition {
+		if order.ClosePosition || isProtectiveOrder(order) {
 			continue
 		}
 		// 取订单时间
-		now := time.Now().UnixMilli()
+		now := clock().UnixMilli()
+		// 信号消失超过宽限时间撤单
+		if !hasSignal(signals, order) {
+			if signalGone(order, now) {
+				log.Println(order.Symbol, "Signal gone")
+				if err := cancelOrder(order.Symbol, order.OrderID); err != nil {
+					log.Println(err)
+					continue
+				}
+				openOrders[i].Symbol = ""
+				continue
+			}
+		} else {
+			delete(signalMissing, order.OrderID)
+		}
+		if config.OrderMode == "chase" && hasSignal(signals, order) {
+			// 追价模式 超时改价追单 没有信号的不追 按超时撤单
+			if now-order.UpdateTime > config.OrdersTimeout*1000 {
+				canceled, err := chaseOrder(order)
+				if err != nil {
+					log.Println(order.Symbol, "[CHASE]", err)
+				}
+				if canceled {
+					openOrders[i].Symbol = ""
+				}
+			}
+			continue
+		}
 		if now-order.UpdateTime > config.OrdersTimeout*1000 {
 			// 判断UpdateTime 更新时间是否过期
 			log.Println(order.Symbol, "Expired")
@@ -332,19 +496,33 @@ func ordersOrders(symbols []FundData) error {
 		}
 
 	}
+	// 保护单不算开仓挂单
+	entryOrders := make([]*futures.Order, 0, len(openOrders))
+	for _, order := range openOrders {
+		if !isProtectiveOrder(order) {
+			entryOrders = append(entryOrders, order)
+		}
+	}
+	// 每个合约剩余的挂单数 交易规则 MAX_NUM_ORDERS 限制
+	orderCounts := make(map[string]int)
+	for _, order := range openOrders {
+		if order.Symbol != "" {
+			orderCounts[order.Symbol]++
+		}
+	}
 	// 开始挂单
 	for _, symbol := range symbols {
-		order, err := getOrderSymbolsFundData(openOrders, symbol.Coin+"USDT")
+		order, err := getOrderSymbolsFundData(entryOrders, binanceSymbol(symbol.Coin))
 		if err != nil { // 没有持有
 			log.Println(symbol.Coin, "Order")
 			if symbol.Side {
-				err = placeOrder(symbol.Coin+"USDT", "BUY", "LONG", true)
+				err = placeOrder(binanceSymbol(symbol.Coin), "BUY", "LONG", true, orderCounts[binanceSymbol(symbol.Coin)], record)
 				if err != nil {
 					log.Println(err)
 					continue
 				}
 			} else {
-				err = placeOrder(symbol.Coin+"USDT", "SELL", "SHORT", true)
+				err = placeOrder(binanceSymbol(symbol.Coin), "SELL", "SHORT", true, orderCounts[binanceSymbol(symbol.Coin)], record)
 				if err != nil {
 					log.Println(err)
 					continue
@@ -364,7 +542,7 @@ func ordersOrders(symbols []FundData) error {
 				log.Println(err)
 				continue
 			}
-			err = placeOrder(order.Symbol, "BUY", "LONG", true)
+			err = placeOrder(order.Symbol, "BUY", "LONG", true, orderCounts[order.Symbol]-1, record)
 			if err != nil {
 				log.Println(err)
 				continue
@@ -377,7 +555,7 @@ func ordersOrders(symbols []FundData) error {
 				log.Println(err)
 				continue
 			}
-			err = placeOrder(order.Symbol, "SELL", "SHORT", true)
+			err = placeOrder(order.Symbol, "SELL", "SHORT", true, orderCounts[order.Symbol]-1, record)
 			if err != nil {
 				log.Println(err)
 				continue
@@ -389,24 +567,64 @@ func ordersOrders(symbols []FundData) error {
 	return nil
 }
 
+// 信号消失时开仓挂单的首次发现时间 ms 由 ordersMu 保护
+var signalMissing = make(map[int64]int64)
+
+// 挂单对应的信号是否还在 币种和方向都一致
+func hasSignal(signals []FundData, order *futures.Order) bool {
+	for _, s := range signals {
+		if binanceSymbol(s.Coin) == order.Symbol && s.Side == (order.PositionSide == futures.PositionSideTypeLong) {
+			return true
+		}
+	}
+	return false
+}
+
+// 信号消失是否已超过宽限时间 SignalGrace 不大于0时不按信号撤单 只按超时处理
+func signalGone(order *futures.Order, now int64) bool {
+	if config.SignalGrace <= 0 {
+		return false
+	}
+	since, ok := signalMissing[order.OrderID]
+	if !ok {
+		since = now
+		signalMissing[order.OrderID] = now
+	}
+	return now-since >= int64(config.SignalGrace)*1000
+}
+
+// 去掉已经不在挂单列表中的订单记录
+func pruneOrderState(openOrders []*futures.Order) {
+	open := make(map[int64]bool, len(openOrders))
+	for _, order := range openOrders {
+		open[order.OrderID] = true
+	}
+	for id := range chaseOrigins {
+		if !open[id] {
+			delete(chaseOrigins, id)
+		}
+	}
+	for id := range signalMissing {
+		if !open[id] {
+			delete(signalMissing, id)
+		}
+	}
+}
+
 // 下单
-func placeOrder(symbol string, side futures.SideType, positionSide futures.PositionSideType, isBook bool) error {
+// openOrders 为该合约已有挂单数 未知时传 -1
+func placeOrder(symbol string, side futures.SideType, positionSide futures.PositionSideType, isBook bool, openOrders int, record *cycleRecord) error {
 	// 取订单铺
-	book, ree := client.NewDepthService().Symbol(symbol).Limit(50).Do(context.Background())
+	book, ree := exchange.Depth(context.Background(), symbol, entryDepthLimit())
 	if ree != nil {
 		log.Println(ree)
 		return ree
 	}
-	var price string
-
-	if side == "BUY" && positionSide == "LONG" {
-		price = book.Bids[config.PriceDepth-1].Price
-	} else if side == "SELL" && positionSide == "LONG" {
-		price = book.Asks[config.PriceDepth-1].Price
-	} else if side == "BUY" && positionSide == "SHORT" {
-		price = book.Bids[config.PriceDepth-1].Price
-	} else if side == "SELL" && positionSide == "SHORT" {
-		price = book.Asks[config.PriceDepth-1].Price
+	record.AddDepth(symbol, book)
+	price, timeInForce, err := entryPrice(symbol, side, book)
+	if err != nil {
+		log.Println(err)
+		return err
 	}
 
 	prices, err := strconv.ParseFloat(price, 64)
@@ -416,40 +634,119 @@ func placeOrder(symbol string, side futures.SideType, positionSide futures.Posit
 	}
 	log.Println(symbol, side, positionSide, prices)
 	// 根据  prices 和cconfig.amount 计算出数量
-	amount := config.Amount / prices
-	// infoData 取到币种信息 设置数量和价格小数位
-	infoDataSymbols, err := getInfoSymbolsFundData(infoData, symbol)
-	if err != nil {
+	// 1000PEPE 等合约的价格为 multiplier 个币的价格，先按币计算再换算为合约数量
+	coin := coinOf(symbol)
+	coinPrice := prices / symbolMultiplier(coin)
+	amount := toContractQuantity(coin, config.Amount/coinPrice)
+	// 按交易规则取整并校验 参考价取买一卖一中间价
+	req := OrderRequest{Symbol: symbol, Type: futures.OrderTypeMarket, Side: side, PositionSide: positionSide, Quantity: formatFloat(amount)}
+	if isBook {
+		req.Type = futures.OrderTypeLimit
+		req.Price = price
+		req.TimeInForce = timeInForce
+	}
+	refPrice := price
+	if len(book.Bids) > 0 && len(book.Asks) > 0 {
+		bid, _ := strconv.ParseFloat(book.Bids[0].Price, 64)
+		ask, _ := strconv.ParseFloat(book.Asks[0].Price, 64)
+		refPrice = formatFloat((bid + ask) / 2)
+	}
+	if err := normalizeOrder(&req, refPrice, openOrders); err != nil {
 		log.Println(err)
 		return err
 	}
-
-	amountStr, err := takeDivisible(amount, infoDataSymbols.Filters[1]["stepSize"].(string))
+	res, err := exchange.CreateOrder(context.Background(), req)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	if isBook {
-		pricesStr := strconv.FormatFloat(prices, 'f', -1, 64)
-		_, err = client.NewCreateOrderService().Symbol(symbol).Type("LIMIT").Price(pricesStr).Side(side).PositionSide(positionSide).Quantity(amountStr).TimeInForce("GTC").Do(context.Background())
+	// 立即成交的开仓单马上挂止损止盈 不等下一次持仓管理
+	if req.Type == futures.OrderTypeMarket || res.Status == futures.OrderStatusTypeFilled || res.Status == futures.OrderStatusTypePartiallyFilled {
+		notifyEntryFill()
+	}
+
+	return nil
+}
+
+// 按实际持仓数量市价平仓 并确认已经平掉
+func closePosition(position *futures.AccountPosition) error {
+	for attempt := 0; attempt < closePositionAttempts; attempt++ {
+		amt, err := strconv.ParseFloat(position.PositionAmt, 64)
 		if err != nil {
-			log.Println(err)
 			return err
 		}
-	} else {
-		_, err = client.NewCreateOrderService().Symbol(symbol).Type("MARKET").Side(side).PositionSide(positionSide).Quantity(amountStr).Do(context.Background())
+		if amt == 0 {
+			return nil
+		}
+		side := futures.SideTypeSell
+		if amt < 0 {
+			side = futures.SideTypeBuy
+		}
+		// 单向持仓模式带 reduceOnly 双向持仓由 positionSide 决定平仓
+		reduceOnly := position.PositionSide == futures.PositionSideTypeBoth
+		// 市价单数量不能超过 MARKET_LOT_SIZE 的 maxQty 超过时分批
+		remaining := math.Abs(amt)
+		maxQty := remaining
+		if v, err := symbolFilter(position.Symbol, "MARKET_LOT_SIZE", "maxQty"); err == nil {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+				maxQty = f
+			}
+		}
+		stepSize, err := symbolFilter(position.Symbol, "LOT_SIZE", "stepSize")
 		if err != nil {
-			log.Println(err)
 			return err
 		}
+		for remaining > 0 {
+			quantity, err := takeDivisible(math.Min(remaining, maxQty)+1e-9, stepSize)
+			if err != nil {
+				return err
+			}
+			qty, _ := strconv.ParseFloat(quantity, 64)
+			if qty <= 0 {
+				break
+			}
+			log.Println(position.Symbol, position.PositionSide, "[CLOSE]", side, quantity)
+			_, err = exchange.CreateOrder(context.Background(), OrderRequest{
+				Symbol:       position.Symbol,
+				Side:         side,
+				PositionSide: position.PositionSide,
+				Type:         futures.OrderTypeMarket,
+				Quantity:     quantity,
+				ReduceOnly:   reduceOnly,
+			})
+			if err != nil {
+				log.Println(err)
+				break
+			}
+			remaining -= qty
+		}
+
+		// 重新取持仓确认 不用数据流缓存 平仓的推送可能还没到
+		positions, err := restPositions(context.Background())
+		if err != nil {
+			return err
+		}
+		position = findPosition(positions, position.Symbol, position.PositionSide)
+		if position == nil {
+			return nil
+		}
 	}
+	return fmt.Errorf("%s %s 平仓后仍有持仓 %s", position.Symbol, position.PositionSide, position.PositionAmt)
+}
 
+// 按合约与方向查找持仓
+func findPosition(positions []*futures.AccountPosition, symbol string, positionSide futures.PositionSideType) *futures.AccountPosition {
+	for _, p := range positions {
+		if p.Symbol == symbol && p.PositionSide == positionSide {
+			return p
+		}
+	}
 	return nil
 }
 
 // 取消订单
 func cancelOrder(symbol string, orderId int64) error {
-	_, err := client.NewCancelOrderService().Symbol(symbol).OrderID(orderId).Do(context.Background())
+	err := exchange.CancelOrder(context.Background(), symbol, orderId)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -457,27 +754,9 @@ func cancelOrder(symbol string, orderId int64) error {
 	return nil
 }
 
-// 调整小数位数并确保可以整除
+// 调整小数位数并确保可以整除 十进制计算 向零取整
 func takeDivisible(inputVal float64, divisor string) (string, error) {
-
-	divisorVal, err := strconv.ParseFloat(divisor, 64)
-	if err != nil || divisorVal == 0 {
-		return "", fmt.Errorf("无效的 divisor: %v", err)
-	}
-
-	// 计算小数点位数
-	decimalPlaces := 0
-	if dot := strings.Index(divisor, "."); dot != -1 {
-		decimalPlaces = len(divisor) - dot - 1
-	}
-
-	// 计算最大整除的值
-	quotient := int(inputVal / divisorVal)
-	maxDivisible := divisorVal * float64(quotient)
-
-	// 格式化输出
-	format := fmt.Sprintf("%%.%df", decimalPlaces)
-	return fmt.Sprintf(format, maxDivisible), nil
+	return roundStep(decimalOf(inputVal), divisor, roundTrunc)
 }
 
 // 取得InfoSymbo币种数据
@@ -490,6 +769,20 @@ func getInfoSymbolsFundData(symbols *futures.ExchangeInfo, symbolName string) (g
 	return getSymbol, fmt.Errorf("没有找到%s", symbolName)
 }
 
+// 合约交易规则中某个过滤器的值 例如 LOT_SIZE stepSize
+func symbolFilter(symbol, filterType, key string) (string, error) {
+	s, err := getInfoSymbolsFundData(infoData, symbol)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range s.Filters {
+		if f["filterType"] == filterType && f[key] != nil {
+			return fmt.Sprint(f[key]), nil
+		}
+	}
+	return "", fmt.Errorf("%s 没有 %s %s", symbol, filterType, key)
+}
+
 // 取得当前挂单的币种信息
 func getOrderSymbolsFundData(symbols []*futures.Order, symbolName string) (getSymbol *futures.Order, err error) {
 	for _, s := range symbols {
@@ -509,11 +802,11 @@ func getAccountPositionSymbolsFundData(symbols []*futures.AccountPosition, symbo
 		side = "SHORT"
 	}
 	for _, s := range symbols {
-		if s.Symbol == symbolName.Coin+"USDT" && s.PositionSide == side {
+		if s.Symbol == binanceSymbol(symbolName.Coin) && s.PositionSide == side {
 			return s, nil
 		}
 	}
-	return getSymbol, fmt.Errorf("没有找到%s", symbolName)
+	return getSymbol, fmt.Errorf("没有找到%s", symbolName.Coin)
 }
 
 // 取得当前仓位的币种信息 反
@@ -525,11 +818,11 @@ func getAccountPositionSymbolsFundDataFan(symbols []*futures.AccountPosition, sy
 		side = "LONG"
 	}
 	for _, s := range symbols {
-		if s.Symbol == symbolName.Coin+"USDT" && s.PositionSide == side {
+		if s.Symbol == binanceSymbol(symbolName.Coin) && s.PositionSide == side {
 			return s, nil
 		}
 	}
-	return getSymbol, fmt.Errorf("没有找到%s", symbolName)
+	return getSymbol, fmt.Errorf("没有找到%s", symbolName.Coin)
 }
 
 // 取得币种数据
@@ -542,14 +835,6 @@ func getSymbolsFundData(symbols []FundData, symbolName string) (getSymbol FundDa
 	return getSymbol, fmt.Errorf("没有找到%s", symbolName)
 }
 
-// Coinank-Apikey 获取
-func getKey() string {
-	signStr := fmt.Sprintf("%s|%d%d", "-b31e-c547-d299-b6d07b7631aba2c903cca2c903cc", time.Now().UnixNano()/int64(time.Millisecond)+1111111111111, 347)
-	originalBytes := []byte(signStr)
-	base64Bytes := base64.StdEncoding.EncodeToString(originalBytes)
-	return base64Bytes
-}
-
 // 检查一个字符串是否在切片中
 func contains(slice []string, item string) bool {
 	for _, v := range slice {
@@ -560,70 +845,6 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-// 取Coinank数据
-func fetchFundCoinankData() ([]FundData, error) {
-	req, err := http.NewRequest("POST", "https://coinank.com/api/fund/fundReal?page=1&size=50&type=1&productType=SWAP&sortBy=&baseCoin=&isFollow=false", nil)
-	if err != nil {
-		// log.Printf("创建请求失败: %v", err)
-		return nil, err
-	}
-	req.Header.Add("coinank-apikey", getKey())
-
-	// 发送请求
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		// log.Printf("请求失败: %v", err)
-		return nil, err
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Println(err)
-		}
-	}(resp.Body)
-
-	// 检查状态码
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("请求失败，状态码: %d", resp.StatusCode)
-	}
-
-	// 解析响应
-	var responseData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
-		// log.Printf("解析响应失败: %v", err)
-		return nil, err
-	}
-
-	// 检查 success 字段
-	if success, ok := responseData["success"].(bool); !ok || !success {
-		return nil, fmt.Errorf("API返回数据失败，内容: %v", responseData)
-	}
-
-	// 处理数据
-	dataList := responseData["data"].(map[string]interface{})["list"].([]interface{})
-	fundDataList := make([]FundData, 0)
-
-	for _, item := range dataList {
-		itemMap := item.(map[string]interface{})
-		Coin := itemMap["baseCoin"].(string)
-
-		// 检查是否在名单中
-		if contains(symbolsString, Coin) {
-			fundData := FundData{
-				Coin:   Coin,
-				Side:   itemMap["m5net"].(float64) > 50*10000,
-				M5Net:  itemMap["m5net"].(float64),
-				M15Net: itemMap["m15net"].(float64),
-				// 处理其他字段...
-			}
-			// 使用 append 添加到切片
-			fundDataList = append(fundDataList, fundData)
-		}
-	}
-
-	return fundDataList, nil
-}
-
 // 取高低数据
 func getTopAndBottomM5Net(data []FundData) (symbolsNet []FundData, err error) {
 	// 使用 sort.Slice 来排序
@@ -658,15 +879,25 @@ func getTopAndBottomM5Net(data []FundData) (symbolsNet []FundData, err error) {
 }
 
 // 筛选币种
-func filterSymbols(symbols []FundData) ([]FundData, error) {
+func filterSymbols(symbols []FundData, record *cycleRecord) ([]FundData, error) {
 	target := make([]FundData, 0)
+	// 并发拉取 单个币种失败只跳过该币种
+	symbolNames := make([]string, 0, len(symbols))
 	for _, s := range symbols {
-		klines, err := client.NewKlinesService().Symbol(s.Coin + "USDT").
-			Interval("5m").Limit(202).Do(context.Background())
+		symbolNames = append(symbolNames, binanceSymbol(s.Coin))
+	}
+	klineList, errs := fetchKlines(symbolNames, "5m", 202)
+	for i, s := range symbols {
+		klines, err := klineList[i], errs[i]
 		if err != nil {
-			fmt.Println(err)
-			return nil, err
+			log.Println(s.Coin, err)
+			continue
+		}
+		if len(klines) < 202 {
+			log.Println(s.Coin, "K线不足", len(klines))
+			continue
 		}
+		record.AddKlines(binanceSymbol(s.Coin), klines)
 		closedPrices := make([]float64, 0, len(klines)-1)
 		for _, kline := range klines[:len(klines)-1] {
 			closeFloat, err := strconv.ParseFloat(kline.Close, 64)
@@ -682,25 +913,53 @@ func filterSymbols(symbols []FundData) ([]FundData, error) {
 		src200 := fmt.Sprintf("%.2f", crsi[200])
 		M5Net, _ := takeDivisible(s.M5Net/1000000, "0.01")
 		M15Net, _ := takeDivisible(s.M15Net/1000000, "0.01")
+		// 多周期确认
+		if !confirmFlow(s) {
+			log.Println("["+s.Coin+"][SKIP][CONFIRM] | ", "M5:", M5Net, " H1:", s.H1Net, " H4:", s.H4Net)
+			continue
+		}
 		// VOL
-		if s.Side && s.M5Net > config.BuyNetAmount && s.M15Net > 1 && s.M15Net > (s.M5Net*config.MultipleNetAmount) {
+		if s.Side && netTrigger(s, true) && s.M15Net > 1 && s.M15Net > (s.M5Net*config.MultipleNetAmount) {
 			log.Println("["+s.Coin+"][LONG][VOL] | ", "RSI:", src200, " M5:", M5Net, " M15:", M15Net)
+			s.Signal = "VOL"
 			target = append(target, s)
 			continue
 		}
-		if !s.Side && s.M5Net < -config.SideNetAmount && s.M15Net < 1 && s.M15Net < (s.M5Net*config.MultipleNetAmount) {
+		if !s.Side && netTrigger(s, false) && s.M15Net < 1 && s.M15Net < (s.M5Net*config.MultipleNetAmount) {
 			log.Println("["+s.Coin+"][SHORT][VOL] | ", "RSI:", src200, " M5:", M5Net, " M15:", M15Net)
+			s.Signal = "VOL"
 			target = append(target, s)
 			continue
 		}
+		// 期现背离
+		// 现货资金流拉取失败的币种本轮不判断
+		if config.DivergenceAmount > 0 && !s.SpotMissing {
+			divergence := flowDivergence(s)
+			SpotM5Net, _ := takeDivisible(s.SpotM5Net/1000000, "0.01")
+			DIV, _ := takeDivisible(divergence/1000000, "0.01")
+			if s.Side && s.M5Net > 0 && s.SpotM5Net < 0 && divergence > config.DivergenceAmount {
+				log.Println("["+s.Coin+"][LONG][DIV] | ", "RSI:", src200, " M5:", M5Net, " SPOT:", SpotM5Net, " DIV:", DIV)
+				s.Signal = "DIV"
+				target = append(target, s)
+				continue
+			}
+			if !s.Side && s.M5Net < 0 && s.SpotM5Net > 0 && divergence < -config.DivergenceAmount {
+				log.Println("["+s.Coin+"][SHORT][DIV] | ", "RSI:", src200, " M5:", M5Net, " SPOT:", SpotM5Net, " DIV:", DIV)
+				s.Signal = "DIV"
+				target = append(target, s)
+				continue
+			}
+		}
 		// CRSI
 		if s.Side && crsi[200] < config.RsiLevel {
 			log.Println("["+s.Coin+"][LONG][RSI] | ", "RSI:", src200, " M5:", M5Net, " M15:", M15Net)
+			s.Signal = "RSI"
 			target = append(target, s)
 			continue
 		}
 		if !s.Side && crsi[200] > (100-config.RsiLevel) {
 			log.Println("["+s.Coin+"][SHORT][RSI] | ", "RSI:", src200, " M5:", M5Net, " M15:", M15Net)
+			s.Signal = "RSI"
 			target = append(target, s)
 			continue
 		}
@@ -710,6 +969,40 @@ func filterSymbols(symbols []FundData) ([]FundData, error) {
 	return target, nil
 }
 
+// 期现背离 合约与现货5分钟净流入之差 合约流入而现货流出时为正
+func flowDivergence(s FundData) float64 {
+	return s.M5Net - s.SpotM5Net
+}
+
+// 多周期确认 ConfirmHorizons 中的周期净流入须与5分钟方向一致
+func confirmFlow(s FundData) bool {
+	for _, horizon := range config.ConfirmHorizons {
+		var net float64
+		switch horizon {
+		case "m15":
+			net = s.M15Net
+		case "m30":
+			net = s.M30Net
+		case "h1":
+			net = s.H1Net
+		case "h4":
+			net = s.H4Net
+		case "d1":
+			net = s.D1Net
+		default:
+			log.Println("未知的确认周期:", horizon)
+			return false
+		}
+		if s.Side && net <= config.ConfirmMinNet {
+			return false
+		}
+		if !s.Side && net >= -config.ConfirmMinNet {
+			return false
+		}
+	}
+	return true
+}
+
 // 链接检查
 func checkConnection(url string) bool {
 	// 检查 URL 是否正确
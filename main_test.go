@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+func TestFilterSymbolsDivergence(t *testing.T) {
+	savedConfig, savedExchange := config, exchange
+	defer func() { config, exchange = savedConfig, savedExchange }()
+	config = Config{
+		RsiLength:        14,
+		RsiLevel:         0,
+		BuyNetAmount:     1e12,
+		SideNetAmount:    1e12,
+		DivergenceAmount: 1000000,
+	}
+	flat := testKlines(func(i int) float64 { return 1 }, 202)
+	exchange = &klineExchange{klines: map[string][]*futures.Kline{
+		"AAAUSDT": flat, "BBBUSDT": flat, "CCCUSDT": flat, "DDDUSDT": flat, "EEEUSDT": flat,
+	}}
+
+	signals, err := filterSymbols([]FundData{
+		// 合约流入 现货流出
+		{Coin: "AAA", Side: true, M5Net: 800000, SpotM5Net: -700000},
+		// 现货比合约流入更多 不算背离
+		{Coin: "BBB", Side: true, M5Net: 100000, SpotM5Net: 2000000},
+		// 合约流出 现货流入
+		{Coin: "CCC", Side: false, M5Net: -900000, SpotM5Net: 300000},
+		// 同向
+		{Coin: "DDD", Side: false, M5Net: -3000000, SpotM5Net: -100000},
+		// 现货资金流没取到
+		{Coin: "EEE", Side: true, M5Net: 2000000, SpotMissing: true},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, s := range signals {
+		got[s.Coin] = s.Signal
+	}
+	if len(got) != 2 || got["AAA"] != "DIV" || got["CCC"] != "DIV" {
+		t.Fatalf("signals = %v, want AAA and CCC DIV", got)
+	}
+}
+
+func TestSignalGone(t *testing.T) {
+	savedConfig := config
+	defer func() { config = savedConfig }()
+	order := &futures.Order{OrderID: 42}
+	defer delete(signalMissing, order.OrderID)
+
+	// 不配置时只按超时撤单
+	config = Config{}
+	if signalGone(order, 0) || signalGone(order, 3600*1000) {
+		t.Fatal("signalGrace=0 should not cancel on missing signal")
+	}
+
+	config.SignalGrace = 60
+	if signalGone(order, 1000) {
+		t.Fatal("canceled on first miss")
+	}
+	if signalGone(order, 60*1000) {
+		t.Fatal("canceled before grace elapsed")
+	}
+	if !signalGone(order, 61*1000) {
+		t.Fatal("not canceled after grace elapsed")
+	}
+}
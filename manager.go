@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 持仓管理 同一时间只运行一个
+var managerMu sync.Mutex
+
+// atr 移动止损 启动后每个持仓的最优价格
+var trailExtremes = make(map[positionKey]float64)
+
+// 保护单 止损止盈与跟踪止损 不按开仓挂单处理
+func isProtectiveOrder(order *futures.Order) bool {
+	return isBracketOrder(order) || order.Type == futures.OrderTypeTrailingStopMarket
+}
+
+// 持仓管理循环 开仓单成交时立即执行 ManageInterval 大于0时再定时执行
+func runManager() {
+	var tick <-chan time.Time
+	if config.ManageInterval > 0 {
+		ticker := time.NewTicker(time.Duration(config.ManageInterval) * time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+		case <-entryFills:
+		}
+		managePositions()
+	}
+}
+
+// 同步止损止盈 再按浮盈移动止损
+func managePositions() {
+	managerMu.Lock()
+	defer managerMu.Unlock()
+	if err := syncBrackets(); err != nil {
+		log.Println("[BRACKET]", err)
+	}
+	if config.BreakEvenProfit <= 0 && config.TrailMode == "" {
+		return
+	}
+	ctx := context.Background()
+	positions, err := exchange.Positions(ctx)
+	if err != nil {
+		log.Println("[MANAGE]", err)
+		return
+	}
+	openOrders, err := exchange.OpenOrders(ctx)
+	if err != nil {
+		log.Println("[MANAGE]", err)
+		return
+	}
+	stops := make(map[positionKey]*futures.Order)
+	trails := make(map[positionKey]*futures.Order)
+	for _, order := range openOrders {
+		key := positionKey{order.Symbol, order.PositionSide}
+		switch {
+		case order.ClosePosition && order.Type == futures.OrderTypeStopMarket:
+			stops[key] = order
+		case order.Type == futures.OrderTypeTrailingStopMarket:
+			trails[key] = order
+		}
+	}
+
+	active := make(map[positionKey]bool)
+	for _, p := range positions {
+		amt, err := strconv.ParseFloat(p.PositionAmt, 64)
+		if err != nil || amt == 0 {
+			continue
+		}
+		entry, err := strconv.ParseFloat(p.EntryPrice, 64)
+		if err != nil || entry <= 0 {
+			continue
+		}
+		key := positionKey{p.Symbol, p.PositionSide}
+		active[key] = true
+		mark := positionMarkPrice(p, amt, entry)
+		// 浮盈 相对开仓价的比例
+		profit := sideSign(p.PositionSide) * (mark - entry) / entry
+		if config.TrailMode == "callback" {
+			syncTrailingStop(p, entry, mark, trails[key])
+		}
+		moveStop(p, key, entry, mark, profit, stops[key])
+	}
+	for key, order := range trails {
+		if !active[key] {
+			log.Println(order.Symbol, order.PositionSide, "[TRAIL] 已平仓 撤销")
+			cancelOrder(order.Symbol, order.OrderID)
+		}
+	}
+	for key := range trailExtremes {
+		if !active[key] {
+			delete(trailExtremes, key)
+		}
+	}
+}
+
+// 当前价格 由名义价值推算 没有时用未实现盈亏
+func positionMarkPrice(p *futures.AccountPosition, amt, entry float64) float64 {
+	if notional, err := strconv.ParseFloat(p.Notional, 64); err == nil && notional != 0 {
+		return notional / amt
+	}
+	profit, _ := strconv.ParseFloat(p.UnrealizedProfit, 64)
+	return entry + profit/amt
+}
+
+// 保本与 atr 移动止损 只向有利方向移动止损单
+func moveStop(p *futures.AccountPosition, key positionKey, entry, mark, profit float64, stop *futures.Order) {
+	sign := sideSign(p.PositionSide)
+	var current float64
+	if stop != nil {
+		current, _ = strconv.ParseFloat(stop.StopPrice, 64)
+	}
+	desired := current
+	tighten := func(price float64) {
+		if price > 0 && (desired == 0 || sign*(price-desired) > 0) {
+			desired = price
+		}
+	}
+	if config.BreakEvenProfit > 0 && profit >= config.BreakEvenProfit {
+		tighten(entry * (1 + sign*config.BreakEvenOffset))
+	}
+	if config.TrailMode == "atr" && profit >= config.TrailActivation {
+		extreme, ok := trailExtremes[key]
+		if !ok || sign*(mark-extreme) > 0 {
+			extreme = mark
+		}
+		trailExtremes[key] = extreme
+		atr, err := symbolATR(p.Symbol)
+		if err != nil {
+			log.Println(p.Symbol, "[TRAIL]", err)
+		} else {
+			tighten(extreme - sign*atr*config.TrailAtr)
+		}
+	}
+	if desired == current {
+		return
+	}
+	// 止损必须在当前价格亏损的一侧 否则会立即触发
+	if sign*(mark-desired) <= 0 {
+		return
+	}
+	tickSize, err := symbolTickSize(p.Symbol)
+	if err != nil {
+		log.Println(p.Symbol, "[MANAGE]", err)
+		return
+	}
+	if tick, _ := strconv.ParseFloat(tickSize, 64); current != 0 && math.Abs(desired-current) < tick {
+		return
+	}
+	if stop != nil {
+		if err := cancelOrder(stop.Symbol, stop.OrderID); err != nil {
+			return
+		}
+	}
+	side := futures.SideTypeSell
+	if p.PositionSide == futures.PositionSideTypeShort {
+		side = futures.SideTypeBuy
+	}
+	log.Println(p.Symbol, p.PositionSide, "[MANAGE] 移动止损", current, "->", desired, "浮盈", profit)
+	if err := placeCloseStop(p.Symbol, side, p.PositionSide, futures.OrderTypeStopMarket, desired, mark); err != nil {
+		log.Println(p.Symbol, "[MANAGE]", err)
+	}
+}
+
+// 跟踪止损单 数量与持仓一致 持仓数量变化时重挂
+func syncTrailingStop(p *futures.AccountPosition, entry, mark float64, trail *futures.Order) {
+	quantity := strings.TrimPrefix(p.PositionAmt, "-")
+	if trail != nil {
+		origQty, _ := strconv.ParseFloat(trail.OrigQuantity, 64)
+		qty, _ := strconv.ParseFloat(quantity, 64)
+		if origQty == qty {
+			return
+		}
+		if err := cancelOrder(trail.Symbol, trail.OrderID); err != nil {
+			return
+		}
+	}
+	side := futures.SideTypeSell
+	if p.PositionSide == futures.PositionSideTypeShort {
+		side = futures.SideTypeBuy
+	}
+	req := OrderRequest{
+		Symbol:       p.Symbol,
+		Side:         side,
+		PositionSide: p.PositionSide,
+		Type:         futures.OrderTypeTrailingStopMarket,
+		Quantity:     quantity,
+		CallbackRate: formatFloat(config.TrailCallbackRate),
+	}
+	// 未到激活价时带上激活价 已经超过时立即激活
+	sign := sideSign(p.PositionSide)
+	if activation := entry * (1 + sign*config.TrailActivation); config.TrailActivation > 0 && sign*(activation-mark) > 0 {
+		tickSize, err := symbolTickSize(p.Symbol)
+		if err != nil {
+			log.Println(p.Symbol, "[TRAIL]", err)
+			return
+		}
+		if req.ActivationPrice, err = takeDivisible(activation, tickSize); err != nil {
+			log.Println(p.Symbol, "[TRAIL]", err)
+			return
+		}
+	}
+	log.Println(p.Symbol, p.PositionSide, "[TRAIL]", quantity, "回调", req.CallbackRate, "激活", req.ActivationPrice)
+	if _, err := exchange.CreateOrder(context.Background(), req); err != nil {
+		log.Println(p.Symbol, "[TRAIL]", err)
+	}
+}
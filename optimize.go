@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 可优化的策略参数 json 名 -> 是否为整数
+var optimizeParams = map[string]bool{
+	"rsiLength":         true,
+	"rsiLevel":          false,
+	"priceDepth":        true,
+	"ordersTimeout":     true,
+	"multipleNetAmount": false,
+	"buyNetAmount":      false,
+	"sideNetAmount":     false,
+	"profitExit":        false,
+}
+
+// 参数范围 Values 不为空时只取这些值 否则 Min 到 Max 步长 Step
+type paramRange struct {
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	Step   float64   `json:"step"`
+	Values []float64 `json:"values"`
+}
+
+// 网格搜索的全部取值
+func (r paramRange) values() []float64 {
+	if len(r.Values) > 0 {
+		return r.Values
+	}
+	if r.Step <= 0 || r.Max < r.Min {
+		return []float64{r.Min}
+	}
+	var values []float64
+	for i := 0; ; i++ {
+		v := r.Min + float64(i)*r.Step
+		if v > r.Max+r.Step*1e-9 {
+			break
+		}
+		values = append(values, roundFloat(v, 10))
+	}
+	return values
+}
+
+// 随机搜索取一个值 有步长时落在网格上
+func (r paramRange) sample(rng *rand.Rand) float64 {
+	if len(r.Values) > 0 {
+		return r.Values[rng.Intn(len(r.Values))]
+	}
+	if r.Step > 0 {
+		values := r.values()
+		return values[rng.Intn(len(values))]
+	}
+	return r.Min + rng.Float64()*(r.Max-r.Min)
+}
+
+// 一次回测结果
+type optimizeResult struct {
+	ID      int                `json:"id"`
+	Params  map[string]float64 `json:"params"`
+	Window  int                `json:"window"`
+	Phase   string             `json:"phase"` // full/train/test
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Score   float64            `json:"score"`
+	Summary *backtestSummary   `json:"summary,omitempty"`
+	Err     string             `json:"err,omitempty"`
+}
+
+// 参数组在各窗口上的汇总
+type leaderboardEntry struct {
+	ID          int                `json:"id"`
+	Params      map[string]float64 `json:"params"`
+	Runs        int                `json:"runs"`
+	Score       float64            `json:"score"` // 各窗口平均
+	Return      float64            `json:"return"`
+	MaxDrawdown float64            `json:"maxDrawdown"` // 各窗口最大
+	Trades      int                `json:"trades"`
+	WinRate     float64            `json:"winRate"`
+}
+
+// 滚动窗口 训练段选参 测试段检验
+type walkForwardWindow struct {
+	Window    int                `json:"window"`
+	Train     backtestWindow     `json:"-"`
+	Test      backtestWindow     `json:"-"`
+	TrainFrom time.Time          `json:"trainFrom"`
+	TrainTo   time.Time          `json:"trainTo"`
+	TestTo    time.Time          `json:"testTo"`
+	BestID    int                `json:"bestId"`
+	Params    map[string]float64 `json:"params"`
+	InScore   float64            `json:"inScore"`
+	OutScore  float64            `json:"outScore"`
+	Result    *optimizeResult    `json:"result"`
+}
+
+// 优化任务参数
+type optimizeOptions struct {
+	recordsDir string
+	dataDir    string
+	outDir     string
+	balance    float64
+	metric     string
+	workers    int
+}
+
+// optimize 命令 网格/随机搜索策略参数 每次回测在子进程中运行以并行
+func runOptimize(args []string) error {
+	flags := flag.NewFlagSet("optimize", flag.ExitOnError)
+	opts := optimizeOptions{}
+	flags.StringVar(&opts.recordsDir, "records", config.ReplayDir, "快照目录")
+	flags.StringVar(&opts.dataDir, "data", "backtest_data", "历史K线与交易信息缓存目录")
+	flags.StringVar(&opts.outDir, "out", "optimize_out", "结果输出目录")
+	flags.Float64Var(&opts.balance, "balance", 1000, "初始余额 USDT")
+	flags.StringVar(&opts.metric, "metric", "return", "排序指标 return/calmar")
+	flags.IntVar(&opts.workers, "workers", runtime.NumCPU(), "并行回测数")
+	rangesFile := flags.String("ranges", "optimize.json", "参数范围文件 例如 {\"rsiLength\":{\"min\":10,\"max\":20,\"step\":2},\"rsiLevel\":{\"values\":[20,25,30]}}")
+	search := flags.String("search", "grid", "搜索方式 grid/random")
+	samples := flags.Int("samples", 100, "random 搜索的参数组数")
+	seed := flags.Int64("seed", 1, "random 搜索的随机种子")
+	train := flags.Duration("train", 0, "滚动窗口训练段长度 例如 72h 为0时整段回测")
+	test := flags.Duration("test", 24*time.Hour, "滚动窗口测试段长度 也是窗口步长")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(*rangesFile)
+	if err != nil {
+		return err
+	}
+	ranges, err := parseRanges(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *rangesFile, err)
+	}
+	var sets []map[string]float64
+	switch *search {
+	case "grid":
+		sets = gridParams(ranges)
+	case "random":
+		sets = randomParams(ranges, *samples, rand.New(rand.NewSource(*seed)))
+	default:
+		return fmt.Errorf("未知的搜索方式 %s", *search)
+	}
+	if len(sets) == 0 {
+		return fmt.Errorf("%s 中没有参数", *rangesFile)
+	}
+	if err := os.MkdirAll(opts.outDir, os.ModePerm); err != nil {
+		return err
+	}
+	log.Println("[OPTIMIZE] 参数组", len(sets), "并行", opts.workers)
+
+	if *train <= 0 {
+		results := opts.runAll(sets, 0, "full", backtestWindow{})
+		board := leaderboard([][]*optimizeResult{results})
+		return writeOptimize(opts.outDir, board, results, nil)
+	}
+
+	windows, err := walkForwardWindows(opts.recordsDir, *train, *test)
+	if err != nil {
+		return err
+	}
+	// 每组参数都跑测试段 排行榜只看样本外得分
+	var all []*optimizeResult
+	var tests [][]*optimizeResult
+	for _, w := range windows {
+		trainResults := opts.runAll(sets, w.Window, "train", w.Train)
+		testResults := opts.runAll(sets, w.Window, "test", w.Test)
+		all = append(all, trainResults...)
+		all = append(all, testResults...)
+		tests = append(tests, testResults)
+		best := bestResult(trainResults)
+		if best == nil {
+			log.Println("[OPTIMIZE] 窗口", w.Window, "没有成功的回测")
+			continue
+		}
+		w.BestID, w.Params, w.InScore = best.ID, best.Params, best.Score
+		w.Result = testResults[best.ID]
+		w.OutScore = w.Result.Score
+		log.Println("[OPTIMIZE] 窗口", w.Window, "参数", best.Params, "训练", formatFloat(w.InScore), "测试", formatFloat(w.OutScore))
+	}
+	return writeOptimize(opts.outDir, leaderboard(tests), all, windows)
+}
+
+// 解析参数范围文件 只接受可优化的参数
+func parseRanges(b []byte) (map[string]paramRange, error) {
+	var ranges map[string]paramRange
+	if err := json.Unmarshal(b, &ranges); err != nil {
+		return nil, err
+	}
+	for name := range ranges {
+		if _, ok := optimizeParams[name]; !ok {
+			return nil, fmt.Errorf("不支持优化参数 %s", name)
+		}
+	}
+	return ranges, nil
+}
+
+// 网格 全部组合
+func gridParams(ranges map[string]paramRange) []map[string]float64 {
+	names := sortedParamNames(ranges)
+	sets := []map[string]float64{{}}
+	for _, name := range names {
+		var next []map[string]float64
+		for _, set := range sets {
+			for _, v := range ranges[name].values() {
+				params := make(map[string]float64, len(names))
+				for k, old := range set {
+					params[k] = old
+				}
+				params[name] = roundParam(name, v)
+				next = append(next, params)
+			}
+		}
+		sets = next
+	}
+	return sets
+}
+
+// 随机 去重后最多 samples 组
+func randomParams(ranges map[string]paramRange, samples int, rng *rand.Rand) []map[string]float64 {
+	names := sortedParamNames(ranges)
+	seen := make(map[string]bool)
+	var sets []map[string]float64
+	for i := 0; i < samples*10 && len(sets) < samples; i++ {
+		params := make(map[string]float64, len(names))
+		for _, name := range names {
+			params[name] = roundParam(name, ranges[name].sample(rng))
+		}
+		b, _ := json.Marshal(params)
+		if seen[string(b)] {
+			continue
+		}
+		seen[string(b)] = true
+		sets = append(sets, params)
+	}
+	return sets
+}
+
+func sortedParamNames(ranges map[string]paramRange) []string {
+	names := make([]string, 0, len(ranges))
+	for name := range ranges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 整数参数取整
+func roundParam(name string, v float64) float64 {
+	if optimizeParams[name] {
+		return math.Round(v)
+	}
+	return v
+}
+
+// 按快照时间切分滚动窗口
+func walkForwardWindows(recordsDir string, train, test time.Duration) ([]*walkForwardWindow, error) {
+	paths, err := listCycleRecords(recordsDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s 中没有快照", recordsDir)
+	}
+	first, err := readCycleRecord(paths[0])
+	if err != nil {
+		return nil, err
+	}
+	last, err := readCycleRecord(paths[len(paths)-1])
+	if err != nil {
+		return nil, err
+	}
+	var windows []*walkForwardWindow
+	for from := first.Time; !from.Add(train).After(last.Time); from = from.Add(test) {
+		w := &walkForwardWindow{
+			Window: len(windows),
+			Train:  backtestWindow{From: from, To: from.Add(train)},
+			Test:   backtestWindow{From: from.Add(train), To: from.Add(train + test)},
+		}
+		w.TrainFrom, w.TrainTo, w.TestTo = w.Train.From, w.Train.To, w.Test.To
+		windows = append(windows, w)
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("快照时间 %s 到 %s 不足一个训练段", first.Time.Format(time.DateTime), last.Time.Format(time.DateTime))
+	}
+	return windows, nil
+}
+
+// 并行回测全部参数组 第一组先单独运行以下载历史数据缓存
+func (opts optimizeOptions) runAll(sets []map[string]float64, window int, phase string, span backtestWindow) []*optimizeResult {
+	results := make([]*optimizeResult, len(sets))
+	results[0] = opts.run(0, sets[0], window, phase, span)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				results[id] = opts.run(id, sets[id], window, phase, span)
+			}
+		}()
+	}
+	for id := 1; id < len(sets); id++ {
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// 子进程运行一次回测 读取其 summary.json
+func (opts optimizeOptions) run(id int, params map[string]float64, window int, phase string, span backtestWindow) *optimizeResult {
+	result := &optimizeResult{ID: id, Params: params, Window: window, Phase: phase, From: span.From, To: span.To}
+	exe, err := os.Executable()
+	if err != nil {
+		result.Err = err.Error()
+		return result
+	}
+	b, _ := json.Marshal(params)
+	outDir := filepath.Join(opts.outDir, "runs", fmt.Sprintf("%d-%s-%d", window, phase, id))
+	args := []string{"backtest",
+		"-records", opts.recordsDir,
+		"-data", opts.dataDir,
+		"-out", outDir,
+		"-balance", formatFloat(opts.balance),
+		"-params", string(b),
+	}
+	if !span.From.IsZero() {
+		args = append(args, "-from", span.From.Format(time.RFC3339), "-to", span.To.Format(time.RFC3339))
+	}
+	cmd := exec.CommandContext(context.Background(), exe, args...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		result.Err = fmt.Sprintf("%v: %s", err, lastLine(output.String()))
+		log.Println("[OPTIMIZE]", window, phase, id, result.Err)
+		return result
+	}
+	summary := new(backtestSummary)
+	b, err = os.ReadFile(filepath.Join(outDir, "summary.json"))
+	if err == nil {
+		err = json.Unmarshal(b, summary)
+	}
+	if err != nil {
+		result.Err = err.Error()
+		return result
+	}
+	result.Summary = summary
+	result.Score = optimizeScore(opts.metric, summary)
+	return result
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
+
+// 排序指标
+func optimizeScore(metric string, summary *backtestSummary) float64 {
+	switch metric {
+	case "calmar":
+		return summary.Return / math.Max(summary.MaxDrawdown, 0.01)
+	default:
+		return summary.Return
+	}
+}
+
+// 得分最高的成功回测
+func bestResult(results []*optimizeResult) *optimizeResult {
+	var best *optimizeResult
+	for _, r := range results {
+		if r.Summary != nil && (best == nil || r.Score > best.Score) {
+			best = r
+		}
+	}
+	return best
+}
+
+// 按参数组汇总各窗口结果 按平均得分排序 滚动窗口时传入的是测试段结果
+func leaderboard(windows [][]*optimizeResult) []*leaderboardEntry {
+	entries := make(map[int]*leaderboardEntry)
+	wins := make(map[int]float64)
+	for _, results := range windows {
+		for _, r := range results {
+			if r.Summary == nil {
+				continue
+			}
+			e, ok := entries[r.ID]
+			if !ok {
+				e = &leaderboardEntry{ID: r.ID, Params: r.Params}
+				entries[r.ID] = e
+			}
+			e.Runs++
+			e.Score += r.Score
+			e.Return += r.Summary.Return
+			e.MaxDrawdown = math.Max(e.MaxDrawdown, r.Summary.MaxDrawdown)
+			e.Trades += r.Summary.Trades
+			wins[r.ID] += r.Summary.WinRate * float64(r.Summary.Trades)
+		}
+	}
+	board := make([]*leaderboardEntry, 0, len(entries))
+	for id, e := range entries {
+		e.Score /= float64(e.Runs)
+		e.Return /= float64(e.Runs)
+		if e.Trades > 0 {
+			e.WinRate = wins[id] / float64(e.Trades)
+		}
+		board = append(board, e)
+	}
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Score != board[j].Score {
+			return board[i].Score > board[j].Score
+		}
+		return board[i].ID < board[j].ID
+	})
+	return board
+}
+
+// 输出 leaderboard.json/csv results.json 与 walkforward.json/csv
+func writeOptimize(outDir string, board []*leaderboardEntry, results []*optimizeResult, windows []*walkForwardWindow) error {
+	files := map[string]interface{}{"leaderboard.json": board, "results.json": results}
+	if windows != nil {
+		files["walkforward.json"] = windows
+	}
+	for name, v := range files {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(outDir, name), b, 0666); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for _, e := range board {
+		for name := range e.Params {
+			if !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	rows := [][]string{append([]string{"rank", "id"}, append(names, "runs", "score", "return", "maxDrawdown", "trades", "winRate")...)}
+	for i, e := range board {
+		row := []string{strconv.Itoa(i + 1), strconv.Itoa(e.ID)}
+		for _, name := range names {
+			row = append(row, formatFloat(e.Params[name]))
+		}
+		row = append(row, strconv.Itoa(e.Runs), formatFloat(e.Score), formatFloat(e.Return), formatFloat(e.MaxDrawdown), strconv.Itoa(e.Trades), formatFloat(e.WinRate))
+		rows = append(rows, row)
+	}
+	if err := writeCSV(filepath.Join(outDir, "leaderboard.csv"), rows); err != nil {
+		return err
+	}
+	if windows == nil {
+		return nil
+	}
+
+	// 测试段收益连乘 即滚动窗口的样本外表现
+	compound := 1.0
+	rows = [][]string{append([]string{"window", "trainFrom", "trainTo", "testTo", "bestId"}, append(names, "inScore", "outScore", "outReturn", "outMaxDrawdown", "outTrades")...)}
+	for _, w := range windows {
+		row := []string{strconv.Itoa(w.Window), w.TrainFrom.Format(time.DateTime), w.TrainTo.Format(time.DateTime), w.TestTo.Format(time.DateTime), strconv.Itoa(w.BestID)}
+		for _, name := range names {
+			row = append(row, formatFloat(w.Params[name]))
+		}
+		var outReturn, outDrawdown float64
+		var outTrades int
+		if w.Result != nil && w.Result.Summary != nil {
+			outReturn, outDrawdown, outTrades = w.Result.Summary.Return, w.Result.Summary.MaxDrawdown, w.Result.Summary.Trades
+		}
+		compound *= 1 + outReturn
+		row = append(row, formatFloat(w.InScore), formatFloat(w.OutScore), formatFloat(outReturn), formatFloat(outDrawdown), strconv.Itoa(outTrades))
+		rows = append(rows, row)
+	}
+	log.Println("[OPTIMIZE] 样本外累计收益", formatFloat(compound-1))
+	return writeCSV(filepath.Join(outDir, "walkforward.csv"), rows)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestOptimizeExampleRanges(t *testing.T) {
+	b, err := os.ReadFile("optimize.test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges, err := parseRanges(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sets := gridParams(ranges); len(sets) != 16 {
+		t.Errorf("grid size = %d, want 16", len(sets))
+	}
+}
+
+func TestLeaderboardRanksByGivenResults(t *testing.T) {
+	result := func(id int, score float64) *optimizeResult {
+		return &optimizeResult{ID: id, Score: score, Summary: &backtestSummary{}}
+	}
+	// 两个测试窗口 参数组 1 的样本外平均得分更高
+	board := leaderboard([][]*optimizeResult{
+		{result(0, 5), result(1, 3), {ID: 2, Err: "failed"}},
+		{result(0, -4), result(1, 2)},
+	})
+	if len(board) != 2 || board[0].ID != 1 || board[0].Score != 2.5 || board[1].Score != 0.5 {
+		t.Fatalf("board = %+v %+v", board[0], board[1])
+	}
+}
+
+func TestOptimizePriceDepth(t *testing.T) {
+	ranges, err := parseRanges([]byte(`{"priceDepth":{"min":1,"max":5,"step":2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := gridParams(ranges)
+	if len(sets) != 3 {
+		t.Fatalf("sets = %v", sets)
+	}
+	// 与子进程回测一样以 -params 的 JSON 覆盖配置
+	savedConfig := config
+	defer func() { config = savedConfig }()
+	b, _ := json.Marshal(sets[2])
+	if err := json.Unmarshal(b, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.PriceDepth != 5 {
+		t.Errorf("PriceDepth = %d, want 5", config.PriceDepth)
+	}
+
+	if _, err := parseRanges([]byte(`{"unknown":{"min":1}}`)); err == nil {
+		t.Error("unknown parameter accepted")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// 下单前校验失败 订单没有发送
+type orderValidationError struct {
+	Symbol string
+	Filter string
+	Msg    string
+}
+
+func (e *orderValidationError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Symbol, e.Filter, e.Msg)
+}
+
+// 取整方向
+type roundMode int
+
+const (
+	roundTrunc roundMode = iota // 向零取整
+	roundFloor                  // 向下
+	roundCeil                   // 向上
+)
+
+// 十进制数 解析失败返回 nil
+func parseDecimal(s string) *big.Rat {
+	if s == "" {
+		return nil
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil
+	}
+	return r
+}
+
+// float64 按最短十进制表示转换 避免二进制误差
+func decimalOf(f float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	return r
+}
+
+// 按步长取整 输出的小数位数与步长一致
+func roundStep(v *big.Rat, step string, mode roundMode) (string, error) {
+	stepVal := parseDecimal(step)
+	if stepVal == nil || stepVal.Sign() <= 0 {
+		return "", fmt.Errorf("无效的步长: %q", step)
+	}
+	q := new(big.Rat).Quo(v, stepVal)
+	n := new(big.Int).Quo(q.Num(), q.Denom())
+	if !q.IsInt() {
+		if mode == roundFloor && q.Sign() < 0 {
+			n.Sub(n, big.NewInt(1))
+		} else if mode == roundCeil && q.Sign() > 0 {
+			n.Add(n, big.NewInt(1))
+		}
+	}
+	decimalPlaces := 0
+	if dot := strings.Index(step, "."); dot != -1 {
+		decimalPlaces = len(step) - dot - 1
+	}
+	return new(big.Rat).Mul(new(big.Rat).SetInt(n), stepVal).FloatString(decimalPlaces), nil
+}
+
+// 合约的全部过滤器 按 filterType 索引
+func symbolFilters(symbol string) (map[string]map[string]interface{}, error) {
+	s, err := getInfoSymbolsFundData(infoData, symbol)
+	if err != nil {
+		return nil, err
+	}
+	filters := make(map[string]map[string]interface{}, len(s.Filters))
+	for _, f := range s.Filters {
+		if filterType, ok := f["filterType"].(string); ok {
+			filters[filterType] = f
+		}
+	}
+	return filters, nil
+}
+
+// 过滤器中的十进制值 没有或为0时返回 nil 表示不限制
+func filterDecimal(filters map[string]map[string]interface{}, filterType, key string) *big.Rat {
+	f, ok := filters[filterType]
+	if !ok || f[key] == nil {
+		return nil
+	}
+	r := parseDecimal(fmt.Sprint(f[key]))
+	if r == nil || r.Sign() == 0 {
+		return nil
+	}
+	return r
+}
+
+// 按交易规则取整价格和数量并校验 refPrice 为当前参考价 用于 PERCENT_PRICE 和市价单的名义价值
+// openOrders 为该合约已有挂单数 未知时传 -1
+func normalizeOrder(req *OrderRequest, refPrice string, openOrders int) error {
+	filters, err := symbolFilters(req.Symbol)
+	if err != nil {
+		return err
+	}
+	invalid := func(filter, format string, args ...interface{}) error {
+		return &orderValidationError{Symbol: req.Symbol, Filter: filter, Msg: fmt.Sprintf(format, args...)}
+	}
+	ref := parseDecimal(refPrice)
+
+	// 价格 买单向下 卖单向上取整 不比设定的更激进
+	var price *big.Rat
+	if req.Price != "" {
+		price = parseDecimal(req.Price)
+		if price == nil || price.Sign() <= 0 {
+			return invalid("PRICE_FILTER", "无效的价格 %s", req.Price)
+		}
+		f, ok := filters["PRICE_FILTER"]
+		tickSize, _ := f["tickSize"].(string)
+		if !ok || tickSize == "" {
+			return invalid("PRICE_FILTER", "缺少 tickSize")
+		}
+		mode := roundFloor
+		if req.Side == "SELL" {
+			mode = roundCeil
+		}
+		if req.Price, err = roundStep(price, tickSize, mode); err != nil {
+			return invalid("PRICE_FILTER", "%v", err)
+		}
+		price = parseDecimal(req.Price)
+		if minPrice := filterDecimal(filters, "PRICE_FILTER", "minPrice"); minPrice != nil && price.Cmp(minPrice) < 0 {
+			return invalid("PRICE_FILTER", "价格 %s 低于 minPrice %s", req.Price, minPrice.FloatString(8))
+		}
+		if maxPrice := filterDecimal(filters, "PRICE_FILTER", "maxPrice"); maxPrice != nil && price.Cmp(maxPrice) > 0 {
+			return invalid("PRICE_FILTER", "价格 %s 高于 maxPrice %s", req.Price, maxPrice.FloatString(8))
+		}
+		// 买单不能高于参考价的 multiplierUp 倍 卖单不能低于 multiplierDown 倍
+		if ref != nil {
+			if up := filterDecimal(filters, "PERCENT_PRICE", "multiplierUp"); up != nil && req.Side == "BUY" && price.Cmp(new(big.Rat).Mul(ref, up)) > 0 {
+				return invalid("PERCENT_PRICE", "买价 %s 高于参考价 %s 的 %s 倍", req.Price, refPrice, up.FloatString(4))
+			}
+			if down := filterDecimal(filters, "PERCENT_PRICE", "multiplierDown"); down != nil && req.Side == "SELL" && price.Cmp(new(big.Rat).Mul(ref, down)) < 0 {
+				return invalid("PERCENT_PRICE", "卖价 %s 低于参考价 %s 的 %s 倍", req.Price, refPrice, down.FloatString(4))
+			}
+		}
+	} else {
+		price = ref
+	}
+
+	// 数量 市价单使用 MARKET_LOT_SIZE
+	if req.Quantity != "" {
+		lotFilter := "LOT_SIZE"
+		if req.Type == "MARKET" {
+			if _, ok := filters["MARKET_LOT_SIZE"]; ok {
+				lotFilter = "MARKET_LOT_SIZE"
+			}
+		}
+		stepSize, _ := filters[lotFilter]["stepSize"].(string)
+		if filterDecimal(filters, lotFilter, "stepSize") == nil {
+			stepSize, _ = filters["LOT_SIZE"]["stepSize"].(string)
+		}
+		if stepSize == "" {
+			return invalid(lotFilter, "缺少 stepSize")
+		}
+		quantity := parseDecimal(req.Quantity)
+		if quantity == nil || quantity.Sign() <= 0 {
+			return invalid(lotFilter, "无效的数量 %s", req.Quantity)
+		}
+		if req.Quantity, err = roundStep(quantity, stepSize, roundFloor); err != nil {
+			return invalid(lotFilter, "%v", err)
+		}
+		quantity = parseDecimal(req.Quantity)
+
+		// 名义价值不足 只减仓的单不限制
+		if minNotional := filterDecimal(filters, "MIN_NOTIONAL", "notional"); minNotional != nil && price != nil && !req.ReduceOnly && !req.ClosePosition {
+			if new(big.Rat).Mul(quantity, price).Cmp(minNotional) < 0 {
+				if !config.MinNotionalBump {
+					return invalid("MIN_NOTIONAL", "名义价值 %s 低于 %s", new(big.Rat).Mul(quantity, price).FloatString(4), minNotional.FloatString(4))
+				}
+				bumped, err := roundStep(new(big.Rat).Quo(minNotional, price), stepSize, roundCeil)
+				if err != nil {
+					return invalid("MIN_NOTIONAL", "%v", err)
+				}
+				log.Println(req.Symbol, "[MIN_NOTIONAL] 数量", req.Quantity, "->", bumped)
+				req.Quantity = bumped
+				quantity = parseDecimal(bumped)
+			}
+		}
+		if minQty := filterDecimal(filters, lotFilter, "minQty"); minQty != nil && quantity.Cmp(minQty) < 0 {
+			return invalid(lotFilter, "数量 %s 低于 minQty %s", req.Quantity, minQty.FloatString(8))
+		}
+		if maxQty := filterDecimal(filters, lotFilter, "maxQty"); maxQty != nil && quantity.Cmp(maxQty) > 0 {
+			return invalid(lotFilter, "数量 %s 高于 maxQty %s", req.Quantity, maxQty.FloatString(8))
+		}
+	}
+
+	// 挂单数量上限
+	if limit := filterDecimal(filters, "MAX_NUM_ORDERS", "limit"); limit != nil && openOrders >= 0 && req.Type != "MARKET" {
+		if new(big.Rat).SetInt64(int64(openOrders)).Cmp(limit) >= 0 {
+			return invalid("MAX_NUM_ORDERS", "已有 %d 个挂单 上限 %s", openOrders, limit.FloatString(0))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 模拟盘默认参数
+const (
+	paperDefaultFile    = "paper.json"
+	paperDefaultBalance = 1000
+	paperPollInterval   = 5 * time.Second
+	paperReportInterval = time.Hour
+	paperMaxTradePages  = 10 // 每次轮询最多追赶的成交页数
+	paperDepthLimit     = 50 // 每次撮合前刷新的实盘深度档数
+)
+
+// 模拟盘按合约统计
+type paperSymbolStats struct {
+	Trades   int     `json:"trades"`
+	Wins     int     `json:"wins"`
+	Realized float64 `json:"realized"` // 已平仓盈亏 含手续费与资金费
+	Fees     float64 `json:"fees"`
+}
+
+// 模拟盘账户文件
+type paperAccount struct {
+	simState
+	Symbols map[string]*paperSymbolStats `json:"symbols"`
+}
+
+// 模拟盘 行情走实盘 账户与下单走本地模拟交易所
+// 有挂单或持仓的合约轮询实盘逐笔成交驱动撮合
+type paperExchange struct {
+	live    Exchange
+	market  *futures.Client
+	sim     *simExchange
+	book    Exchange
+	server  *httptest.Server
+	path    string
+	mu      sync.Mutex // 保护账户文件写入
+	tradeMu sync.Mutex
+	lastIDs map[string]int64 // 各合约已处理的最后一笔成交
+}
+
+func newPaperExchange(live Exchange, market *futures.Client, info *futures.ExchangeInfo, path string, balance float64) (*paperExchange, error) {
+	if path == "" {
+		path = paperDefaultFile
+	}
+	if balance <= 0 {
+		balance = paperDefaultBalance
+	}
+	sim := newSimExchange(info, balance, nil)
+	if b, err := os.ReadFile(path); err == nil {
+		account := new(paperAccount)
+		if err := json.Unmarshal(b, account); err != nil {
+			return nil, err
+		}
+		sim.Restore(&account.simState)
+		log.Println("[PAPER] 恢复模拟账户", path, "余额", account.Balance)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	sim.Advance(time.Now())
+
+	server := httptest.NewServer(sim)
+	simClient := binance.NewFuturesClient("", "")
+	simClient.BaseURL = server.URL
+	return &paperExchange{
+		live:    live,
+		market:  market,
+		sim:     sim,
+		book:    newBinanceExchange(simClient),
+		server:  server,
+		path:    path,
+		lastIDs: make(map[string]int64),
+	}, nil
+}
+
+func (p *paperExchange) SyncTime(ctx context.Context) error {
+	return p.live.SyncTime(ctx)
+}
+
+func (p *paperExchange) ExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error) {
+	return p.live.ExchangeInfo(ctx)
+}
+
+func (p *paperExchange) Depth(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error) {
+	return p.live.Depth(ctx, symbol, limit)
+}
+
+func (p *paperExchange) Klines(ctx context.Context, symbol, interval string, limit int) ([]*futures.Kline, error) {
+	return p.live.Klines(ctx, symbol, interval, limit)
+}
+
+func (p *paperExchange) Tickers(ctx context.Context) ([]*futures.PriceChangeStats, error) {
+	return p.live.Tickers(ctx)
+}
+
+func (p *paperExchange) Account(ctx context.Context) (*futures.Account, error) {
+	p.sim.Advance(time.Now())
+	return p.book.Account(ctx)
+}
+
+func (p *paperExchange) Positions(ctx context.Context) ([]*futures.AccountPosition, error) {
+	p.sim.Advance(time.Now())
+	return p.book.Positions(ctx)
+}
+
+func (p *paperExchange) OpenOrders(ctx context.Context) ([]*futures.Order, error) {
+	return p.book.OpenOrders(ctx)
+}
+
+// 下单前先同步该合约的实盘成交与深度
+func (p *paperExchange) CreateOrder(ctx context.Context, req OrderRequest) (*futures.CreateOrderResponse, error) {
+	if err := p.syncTrades(ctx, req.Symbol); err != nil {
+		return nil, err
+	}
+	res, err := p.book.CreateOrder(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("[PAPER]", req.Symbol, req.Side, req.PositionSide, req.Type, req.Price, req.Quantity, res.Status)
+	return res, p.save()
+}
+
+func (p *paperExchange) CancelOrder(ctx context.Context, symbol string, orderID int64) error {
+	if err := p.book.CancelOrder(ctx, symbol, orderID); err != nil {
+		return err
+	}
+	return p.save()
+}
+
+// 改价前同步成交与深度 改到可成交的价格时按实盘盘口吃单
+func (p *paperExchange) ModifyOrder(ctx context.Context, symbol string, orderID int64, side futures.SideType, quantity, price string) error {
+	if err := p.syncTrades(ctx, symbol); err != nil {
+		return err
+	}
+	if err := p.book.ModifyOrder(ctx, symbol, orderID, side, quantity, price); err != nil {
+		return err
+	}
+	log.Println("[PAPER] 改单", symbol, orderID, side, price, quantity)
+	return p.save()
+}
+
+// 拉取合约自上次以来的实盘逐笔成交 推给模拟交易所撮合 并刷新实盘深度
+func (p *paperExchange) syncTrades(ctx context.Context, symbol string) error {
+	p.tradeMu.Lock()
+	defer p.tradeMu.Unlock()
+	lastID, ok := p.lastIDs[symbol]
+	service := p.market.NewAggTradesService().Symbol(symbol).Limit(1000)
+	if ok {
+		service = service.FromID(lastID + 1)
+	} else {
+		// 首次只取最新一笔作为当前价格
+		service = service.Limit(1)
+	}
+	for page := 0; page < paperMaxTradePages; page++ {
+		trades, err := service.Do(ctx)
+		if err != nil {
+			return err
+		}
+		for _, t := range trades {
+			price, err := strconv.ParseFloat(t.Price, 64)
+			if err != nil {
+				continue
+			}
+			quantity, err := strconv.ParseFloat(t.Quantity, 64)
+			if err != nil {
+				continue
+			}
+			p.sim.Trade(symbol, price, quantity, time.UnixMilli(t.Timestamp))
+			p.lastIDs[symbol] = t.AggTradeID
+		}
+		if !ok || len(trades) < 1000 {
+			break
+		}
+		service = service.FromID(p.lastIDs[symbol] + 1)
+	}
+	book, err := p.live.Depth(ctx, symbol, paperDepthLimit)
+	if err != nil {
+		return err
+	}
+	p.sim.UpdateBook(symbol, book)
+	return nil
+}
+
+// 轮询有挂单或持仓的合约并保存账户
+func (p *paperExchange) Run() {
+	lastReport := time.Now()
+	for {
+		time.Sleep(paperPollInterval)
+		for _, symbol := range p.sim.ActiveSymbols() {
+			if err := p.syncTrades(context.Background(), symbol); err != nil {
+				log.Println("[PAPER]", symbol, err)
+			}
+		}
+		p.sim.PruneBooks()
+		p.sim.Advance(time.Now())
+		if err := p.save(); err != nil {
+			log.Println("[PAPER]", err)
+		}
+		if time.Since(lastReport) >= paperReportInterval {
+			p.Report()
+			lastReport = time.Now()
+		}
+	}
+}
+
+// 由模拟账户状态生成账户文件内容
+func newPaperAccount(state *simState) *paperAccount {
+	account := &paperAccount{simState: *state, Symbols: make(map[string]*paperSymbolStats)}
+	stats := func(symbol string) *paperSymbolStats {
+		s, ok := account.Symbols[symbol]
+		if !ok {
+			s = &paperSymbolStats{}
+			account.Symbols[symbol] = s
+		}
+		return s
+	}
+	for _, trip := range account.Trips {
+		s := stats(trip.Symbol)
+		s.Trades++
+		s.Realized += trip.PnL
+		if trip.PnL > 0 {
+			s.Wins++
+		}
+	}
+	for _, f := range account.Fills {
+		stats(f.Symbol).Fees += f.Fee
+	}
+	return account
+}
+
+// 保存模拟账户 先写临时文件再替换
+func (p *paperExchange) save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, err := json.MarshalIndent(newPaperAccount(p.sim.State()), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// 输出各合约盈亏
+func (p *paperExchange) Report() {
+	account := newPaperAccount(p.sim.State())
+	names := make([]string, 0, len(account.Symbols))
+	for symbol := range account.Symbols {
+		names = append(names, symbol)
+	}
+	sort.Strings(names)
+	for _, symbol := range names {
+		s := account.Symbols[symbol]
+		log.Println("[PAPER]", symbol, "交易", s.Trades, "盈利", s.Wins, "已实现盈亏", formatFloat(s.Realized), "手续费", formatFloat(s.Fees))
+	}
+	log.Println("[PAPER] 余额", formatFloat(account.Balance), "权益", formatFloat(p.sim.Equity()))
+}
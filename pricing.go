@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// REST 深度接口支持的档位数
+var depthLimits = []int{5, 10, 20, 50, 100, 500, 1000}
+
+// 能覆盖 n 档的最小深度档位
+func depthLimit(n int) int {
+	for _, limit := range depthLimits {
+		if n <= limit {
+			return limit
+		}
+	}
+	return depthLimits[len(depthLimits)-1]
+}
+
+// 挂单价格需要的深度档位数
+func entryDepthLimit() int {
+	switch config.PriceMode {
+	case "notional":
+		return 100
+	case "postonly", "atr":
+		return 5
+	default:
+		return depthLimit(config.PriceDepth)
+	}
+}
+
+// 开仓挂单价格 PriceMode:
+// level 第 PriceDepth 档 / notional 前方累计挂单金额达到 PriceNotional 的档位
+// atr 中间价偏移 PriceAtr 个ATR / postonly 买一卖一 只做 maker
+// 深度不够时退到能取到的最深一档
+func entryPrice(symbol string, side futures.SideType, book *futures.DepthResponse) (string, futures.TimeInForceType, error) {
+	levels := book.Bids
+	if side == futures.SideTypeSell {
+		levels = book.Asks
+	}
+	if len(levels) == 0 {
+		return "", "", fmt.Errorf("%s 订单簿为空", symbol)
+	}
+	switch config.PriceMode {
+	case "notional":
+		var notional float64
+		for _, level := range levels {
+			price, _ := strconv.ParseFloat(level.Price, 64)
+			qty, _ := strconv.ParseFloat(level.Quantity, 64)
+			notional += price * qty
+			if notional >= config.PriceNotional {
+				return level.Price, futures.TimeInForceTypeGTC, nil
+			}
+		}
+		log.Println(symbol, "[PRICE] 深度不足", config.PriceNotional, "使用最深一档")
+		return levels[len(levels)-1].Price, futures.TimeInForceTypeGTC, nil
+	case "atr":
+		if len(book.Bids) > 0 && len(book.Asks) > 0 {
+			atr, err := symbolATR(symbol)
+			if err == nil {
+				bid, _ := strconv.ParseFloat(book.Bids[0].Price, 64)
+				ask, _ := strconv.ParseFloat(book.Asks[0].Price, 64)
+				mid := (bid + ask) / 2
+				// 不越过买一卖一 避免成为吃单
+				if side == futures.SideTypeBuy {
+					return formatFloat(math.Min(mid-atr*config.PriceAtr, bid)), futures.TimeInForceTypeGTC, nil
+				}
+				return formatFloat(math.Max(mid+atr*config.PriceAtr, ask)), futures.TimeInForceTypeGTC, nil
+			}
+			log.Println(symbol, "[PRICE]", err, "使用买一卖一")
+		}
+		return levels[0].Price, futures.TimeInForceTypeGTC, nil
+	case "postonly":
+		return levels[0].Price, futures.TimeInForceTypeGTX, nil
+	default:
+		depth := config.PriceDepth
+		if depth < 1 {
+			depth = 1
+		}
+		if depth > len(levels) {
+			log.Println(symbol, "[PRICE] 深度只有", len(levels), "档 使用最深一档")
+			depth = len(levels)
+		}
+		return levels[depth-1].Price, futures.TimeInForceTypeGTC, nil
+	}
+}
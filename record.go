@@ -0,0 +1,242 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// Coinank 原始响应
+type coinankPage struct {
+	ProductType string `json:"productType"`
+	Page        int    `json:"page"`
+	StatusCode  int    `json:"statusCode"`
+	Body        string `json:"body"`
+}
+
+// 单轮输入快照 每轮一份 由该轮的调用链传递 nil 时不记录
+type cycleRecord struct {
+	mu      sync.Mutex
+	Time    time.Time                         `json:"time"`
+	Coinank []coinankPage                     `json:"coinank,omitempty"`
+	Funds   []FundData                        `json:"funds,omitempty"`
+	Klines  map[string][]*futures.Kline       `json:"klines,omitempty"`
+	Depth   map[string]*futures.DepthResponse `json:"depth,omitempty"`
+	Account *futures.Account                  `json:"account,omitempty"`
+}
+
+// 快照记录器 每轮一个 gzip 文件
+type Recorder struct {
+	dir string
+}
+
+// 为空时不记录
+var recorder *Recorder
+
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// 开始新一轮 不记录时返回 nil
+func (r *Recorder) Begin(now time.Time) *cycleRecord {
+	if r == nil {
+		return nil
+	}
+	return &cycleRecord{
+		Time:   now,
+		Klines: make(map[string][]*futures.Kline),
+		Depth:  make(map[string]*futures.DepthResponse),
+	}
+}
+
+// 结束本轮并写出
+func (r *Recorder) End(record *cycleRecord) {
+	if r == nil || record == nil {
+		return
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	if err := r.write(record); err != nil {
+		log.Println("[record]", err)
+	}
+}
+
+func (record *cycleRecord) update(fn func(record *cycleRecord)) {
+	if record == nil {
+		return
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	fn(record)
+}
+
+// 写出 dir/2006-01-02/150405.000.json.gz
+func (r *Recorder) write(record *cycleRecord) error {
+	dir := filepath.Join(r.dir, record.Time.Format("2006-01-02"))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, record.Time.Format("150405.000")+".json.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	zw := gzip.NewWriter(file)
+	if err := json.NewEncoder(zw).Encode(record); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (record *cycleRecord) AddCoinank(query CoinankFundQuery, statusCode int, body []byte) {
+	record.update(func(record *cycleRecord) {
+		record.Coinank = append(record.Coinank, coinankPage{
+			ProductType: query.ProductType,
+			Page:        query.Page,
+			StatusCode:  statusCode,
+			Body:        string(body),
+		})
+	})
+}
+
+func (record *cycleRecord) SetFunds(data []FundData) {
+	record.update(func(record *cycleRecord) {
+		record.Funds = data
+	})
+}
+
+func (record *cycleRecord) AddKlines(symbol string, klines []*futures.Kline) {
+	record.update(func(record *cycleRecord) {
+		record.Klines[symbol] = klines
+	})
+}
+
+func (record *cycleRecord) AddDepth(symbol string, book *futures.DepthResponse) {
+	record.update(func(record *cycleRecord) {
+		record.Depth[symbol] = book
+	})
+}
+
+func (record *cycleRecord) SetAccount(account *futures.Account) {
+	record.update(func(record *cycleRecord) {
+		record.Account = account
+	})
+}
+
+// 列出目录下全部快照文件 按时间排序
+func listCycleRecords(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".json.gz") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// 由快照路径 dir/2006-01-02/150405.000.json.gz 解析时间
+func cycleRecordTime(path string) (time.Time, bool) {
+	name := filepath.Base(filepath.Dir(path)) + " " + strings.TrimSuffix(filepath.Base(path), ".json.gz")
+	t, err := time.ParseInLocation("2006-01-02 150405.000", name, time.Local)
+	return t, err == nil
+}
+
+// 读取快照文件
+func readCycleRecord(path string) (*cycleRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	record := new(cycleRecord)
+	if err := json.NewDecoder(zr).Decode(record); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return record, nil
+}
+
+// 由快照还原资金流 有 Coinank 原始响应时重新解析
+func (record *cycleRecord) FundData() ([]FundData, error) {
+	if len(record.Coinank) == 0 {
+		return record.Funds, nil
+	}
+	funds := make(map[string][]CoinankFund)
+	seen := make(map[string]map[string]bool)
+	for _, page := range record.Coinank {
+		list, err := parseFundReal(page.StatusCode, []byte(page.Body))
+		if err != nil {
+			return nil, fmt.Errorf("%s page %d: %w", page.ProductType, page.Page, err)
+		}
+		if seen[page.ProductType] == nil {
+			seen[page.ProductType] = make(map[string]bool)
+		}
+		for _, fund := range list {
+			if seen[page.ProductType][fund.BaseCoin] {
+				continue
+			}
+			seen[page.ProductType][fund.BaseCoin] = true
+			funds[page.ProductType] = append(funds[page.ProductType], fund)
+		}
+	}
+	swap := funds[coinankQueryFromConfig().ProductType]
+	return fundDataFromCoinank(swap, funds["SPOT"]), nil
+}
+
+// 快照回放数据源 每次调用返回下一轮
+type replaySource struct {
+	mu    sync.Mutex
+	paths []string
+	next  int
+}
+
+func newReplaySource(dir string) (*replaySource, error) {
+	paths, err := listCycleRecords(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s 中没有快照", dir)
+	}
+	return &replaySource{paths: paths}, nil
+}
+
+func (s *replaySource) Fetch() ([]FundData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next >= len(s.paths) {
+		return nil, io.EOF
+	}
+	record, err := readCycleRecord(s.paths[s.next])
+	s.next++
+	if err != nil {
+		return nil, err
+	}
+	return record.FundData()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+func TestRecorderOverlappingCycles(t *testing.T) {
+	r, err := NewRecorder(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	a := r.Begin(start)
+	// 上一轮还没结束 下一轮已经开始
+	b := r.Begin(start.Add(time.Minute))
+	a.AddKlines("AAAUSDT", []*futures.Kline{{Close: "1"}})
+	b.AddKlines("BBBUSDT", []*futures.Kline{{Close: "2"}})
+	r.End(b)
+	a.AddDepth("AAAUSDT", &futures.DepthResponse{LastUpdateID: 7})
+	r.End(a)
+
+	paths, err := listCycleRecords(r.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("got %d records, want 2", len(paths))
+	}
+	first, err := readCycleRecord(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Klines) != 1 || first.Klines["AAAUSDT"] == nil || first.Depth["AAAUSDT"] == nil {
+		t.Errorf("first record = %+v", first)
+	}
+	second, err := readCycleRecord(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Klines) != 1 || second.Klines["BBBUSDT"] == nil || len(second.Depth) != 0 {
+		t.Errorf("second record = %+v", second)
+	}
+
+	// 不记录时各方法都可直接调用
+	var none *Recorder
+	record := none.Begin(start)
+	record.AddKlines("AAAUSDT", nil)
+	none.End(record)
+}
@@ -0,0 +1,83 @@
+package main
+
+// 默认多空阈值
+const (
+	sideDefaultLongNet  = 50 * 10000
+	sideDefaultShortNet = -50 * 10000
+)
+
+// 多空判断规则 M5Net 大于 LongNet 为多，小于 ShortNet 为空，之间为中性
+type SideRule struct {
+	Name           string   `json:"name"`           // 分层名称 用于日志
+	Coins          []string `json:"coins"`          // 适用币种 为空时按成交额匹配
+	MinQuoteVolume float64  `json:"minQuoteVolume"` // 24小时成交额下限 Coins 为空时生效
+	LongNet        float64  `json:"longNet"`        // 做多阈值
+	ShortNet       float64  `json:"shortNet"`       // 做空阈值
+	ZScore         float64  `json:"zScore"`         // 大于0时按该币种历史标准分判断 历史不足时回退到 LongNet/ShortNet
+}
+
+// 默认规则
+var defaultSideRule = SideRule{Name: "default", LongNet: sideDefaultLongNet, ShortNet: sideDefaultShortNet}
+
+// 按顺序匹配第一个适用的规则
+func sideRuleFor(s FundData) SideRule {
+	for _, rule := range config.SideRules {
+		if len(rule.Coins) > 0 {
+			if contains(rule.Coins, s.Coin) {
+				return rule
+			}
+			continue
+		}
+		if s.QuoteVolume >= rule.MinQuoteVolume {
+			return rule
+		}
+	}
+	return defaultSideRule
+}
+
+// 是否需要24小时成交额
+func sideRulesNeedVolume() bool {
+	for _, rule := range config.SideRules {
+		if len(rule.Coins) == 0 && rule.MinQuoteVolume > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断多空 ok 为假表示处于中性区间
+func classifySide(s FundData) (side bool, ok bool) {
+	rule := sideRuleFor(s)
+	if rule.ZScore > 0 {
+		if z, enough := fundHistory.ZScore(s.Coin, snapshotM5, s.M5Net); enough {
+			if z >= rule.ZScore {
+				return true, true
+			}
+			if z <= -rule.ZScore {
+				return false, true
+			}
+			return false, false
+		}
+	}
+	if s.M5Net > rule.LongNet {
+		return true, true
+	}
+	if s.M5Net < rule.ShortNet {
+		return false, true
+	}
+	return false, false
+}
+
+// 设置多空方向 去掉中性区间的币种
+func classifySides(data []FundData) []FundData {
+	target := make([]FundData, 0, len(data))
+	for _, s := range data {
+		side, ok := classifySide(s)
+		if !ok {
+			continue
+		}
+		s.Side = side
+		target = append(target, s)
+	}
+	return target
+}
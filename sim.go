@@ -0,0 +1,1340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 模拟交易所默认参数
+const (
+	simDefaultLeverage    = 10
+	simDefaultMakerFee    = 0.0002
+	simDefaultTakerFee    = 0.0005
+	simDefaultFundingRate = 0.0001
+	simFundingInterval    = 8 * time.Hour
+	simKlinePeriod        = 5 * time.Minute // 加载的K线周期 更长的周期由它合成
+	simEpsilon            = 1e-12
+)
+
+// 模拟挂单
+type simOrder struct {
+	order    futures.Order
+	price    float64
+	quantity float64
+	executed float64
+	queue    float64 // 同价位排在前面的数量
+	stop     float64 // 条件单触发价
+	tag      string  // 触发的信号类型
+	placed   int64   // 下单或改价的时间 ms 之前开盘的K线只按收盘价撮合
+
+	// 跟踪止损 激活价、回调比例、是否已激活与激活后的最优价格
+	activation float64
+	callback   float64
+	activated  bool
+	extreme    float64
+}
+
+func (o *simOrder) remaining() float64 {
+	return o.quantity - o.executed
+}
+
+// 模拟持仓 Amt 空单为负
+type simPosition struct {
+	Amt   float64   `json:"amt"`
+	Entry float64   `json:"entry"`
+	Tag   string    `json:"tag"`
+	Open  time.Time `json:"open"`
+	PnL   float64   `json:"pnl"` // 本轮持仓累计盈亏 含手续费与资金费
+}
+
+// 持仓键
+type simKey struct {
+	Symbol       string
+	PositionSide futures.PositionSideType
+}
+
+// 成交记录
+type simFill struct {
+	Time         time.Time                `json:"time"`
+	OrderID      int64                    `json:"orderId"`
+	Symbol       string                   `json:"symbol"`
+	Side         futures.SideType         `json:"side"`
+	PositionSide futures.PositionSideType `json:"positionSide"`
+	Price        float64                  `json:"price"`
+	Quantity     float64                  `json:"quantity"`
+	Realized     float64                  `json:"realized"`
+	Fee          float64                  `json:"fee"`
+	Maker        bool                     `json:"maker"`
+	Tag          string                   `json:"tag"`
+}
+
+// 完整的一次开平仓
+type simRoundTrip struct {
+	Symbol       string                   `json:"symbol"`
+	PositionSide futures.PositionSideType `json:"positionSide"`
+	Tag          string                   `json:"tag"`
+	Open         time.Time                `json:"open"`
+	Close        time.Time                `json:"close"`
+	PnL          float64                  `json:"pnl"`
+}
+
+// 模拟交易所 以本地 HTTP 服务提供机器人用到的 Binance 合约接口
+// 价格来自 K 线（Advance）或逐笔成交（Trade），挂单按价位排队撮合，计手续费与资金费
+type simExchange struct {
+	mu          sync.Mutex
+	now         time.Time
+	balance     float64
+	leverage    float64
+	makerFee    float64
+	takerFee    float64
+	fundingRate float64
+	loadFunding func(symbol string) ([]*futures.FundingRate, error) // 历史资金费率 为空时使用 fundingRate
+	fundings    map[string][]*futures.FundingRate
+	info        *futures.ExchangeInfo
+	loadKlines  func(symbol string) ([]*futures.Kline, error)
+	klines      map[string][]*futures.Kline
+	cursor      map[string]int
+	prices      map[string]float64
+	levelQty    map[string]float64                // 每个价位的估计挂单量
+	books       map[string]*futures.DepthResponse // 外部深度 模拟盘使用实盘深度
+	orders      []*simOrder
+	positions   map[simKey]*simPosition
+	fills       []simFill
+	trips       []simRoundTrip
+	funding     float64 // 累计资金费 正为支出
+	nextFunding time.Time
+	nextID      int64
+}
+
+func newSimExchange(info *futures.ExchangeInfo, balance float64, loadKlines func(symbol string) ([]*futures.Kline, error)) *simExchange {
+	e := &simExchange{
+		balance:    balance,
+		leverage:   simDefaultLeverage,
+		makerFee:   simDefaultMakerFee,
+		takerFee:   simDefaultTakerFee,
+		info:       info,
+		loadKlines: loadKlines,
+		klines:     make(map[string][]*futures.Kline),
+		cursor:     make(map[string]int),
+		prices:     make(map[string]float64),
+		levelQty:   make(map[string]float64),
+		fundings:   make(map[string][]*futures.FundingRate),
+		books:      make(map[string]*futures.DepthResponse),
+		positions:  make(map[simKey]*simPosition),
+		nextID:     1,
+	}
+	if config.SimLeverage > 0 {
+		e.leverage = config.SimLeverage
+	}
+	if config.SimMakerFee != 0 {
+		e.makerFee = config.SimMakerFee
+	}
+	if config.SimTakerFee != 0 {
+		e.takerFee = config.SimTakerFee
+	}
+	e.fundingRate = simDefaultFundingRate
+	if config.SimFundingRate != 0 {
+		e.fundingRate = config.SimFundingRate
+	}
+	return e
+}
+
+func (e *simExchange) Now() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.now
+}
+
+// 取K线 首次使用时加载
+func (e *simExchange) symbolKlines(symbol string) ([]*futures.Kline, error) {
+	if klines, ok := e.klines[symbol]; ok {
+		return klines, nil
+	}
+	if e.loadKlines == nil {
+		return nil, fmt.Errorf("%s 没有K线数据", symbol)
+	}
+	klines, err := e.loadKlines(symbol)
+	if err != nil {
+		return nil, err
+	}
+	e.klines[symbol] = klines
+	// 跳过当前时间之前的K线
+	i := 0
+	for i < len(klines) && klines[i].CloseTime < e.now.UnixMilli() {
+		i++
+	}
+	e.cursor[symbol] = i
+	if i > 0 {
+		e.updateLevel(symbol, klines[i-1])
+	}
+	return klines, nil
+}
+
+// 以K线更新最新价与价位挂单量估计
+func (e *simExchange) updateLevel(symbol string, kline *futures.Kline) {
+	high, _ := strconv.ParseFloat(kline.High, 64)
+	low, _ := strconv.ParseFloat(kline.Low, 64)
+	closePrice, _ := strconv.ParseFloat(kline.Close, 64)
+	volume, _ := strconv.ParseFloat(kline.Volume, 64)
+	e.prices[symbol] = closePrice
+	e.levelQty[symbol] = volume / math.Max(1, (high-low)/e.tickSize(symbol))
+}
+
+// 最新价格
+func (e *simExchange) lastPrice(symbol string) (float64, error) {
+	if price, ok := e.prices[symbol]; ok {
+		return price, nil
+	}
+	if _, err := e.symbolKlines(symbol); err != nil {
+		return 0, err
+	}
+	if price, ok := e.prices[symbol]; ok {
+		return price, nil
+	}
+	return 0, fmt.Errorf("%s 在 %s 之前没有价格", symbol, e.now.Format(time.DateTime))
+}
+
+// 推进到 t 按时间顺序处理期间收盘的K线
+func (e *simExchange) Advance(t time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for {
+		// 取最早收盘的一根
+		next := ""
+		var closeTime int64
+		for symbol, klines := range e.klines {
+			i := e.cursor[symbol]
+			if i < len(klines) && klines[i].CloseTime < t.UnixMilli() && (next == "" || klines[i].CloseTime < closeTime) {
+				next, closeTime = symbol, klines[i].CloseTime
+			}
+		}
+		if next == "" {
+			break
+		}
+		kline := e.klines[next][e.cursor[next]]
+		e.setTime(time.UnixMilli(kline.CloseTime))
+		e.matchKline(next, kline)
+		e.updateLevel(next, kline)
+		e.cursor[next]++
+	}
+	e.setTime(t)
+}
+
+// 逐笔成交推进 用于实时行情
+func (e *simExchange) Trade(symbol string, price, quantity float64, t time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setTime(t)
+	e.prices[symbol] = price
+	e.matchPath(symbol, price, price, quantity)
+}
+
+// 更新时间 跨过资金费时间点时结算
+func (e *simExchange) setTime(t time.Time) {
+	if t.Before(e.now) {
+		return
+	}
+	if e.nextFunding.IsZero() {
+		e.nextFunding = t.Truncate(simFundingInterval).Add(simFundingInterval)
+	}
+	for !t.Before(e.nextFunding) {
+		e.now = e.nextFunding
+		e.settleFunding()
+		e.nextFunding = e.nextFunding.Add(simFundingInterval)
+	}
+	e.now = t
+}
+
+// 资金费 多头按正费率支付 空头收取
+func (e *simExchange) settleFunding() {
+	for key, p := range e.positions {
+		if p.Amt == 0 {
+			continue
+		}
+		price, ok := e.prices[key.Symbol]
+		if !ok {
+			continue
+		}
+		fee := p.Amt * price * e.fundingRateAt(key.Symbol, e.now)
+		e.balance -= fee
+		e.funding += fee
+		p.PnL -= fee
+	}
+}
+
+// t 时刻的资金费率 有历史数据时取 t 之前最近一次
+func (e *simExchange) fundingRateAt(symbol string, t time.Time) float64 {
+	if e.loadFunding == nil {
+		return e.fundingRate
+	}
+	rates, ok := e.fundings[symbol]
+	if !ok {
+		var err error
+		rates, err = e.loadFunding(symbol)
+		if err != nil {
+			log.Println("[sim]", symbol, err)
+		}
+		e.fundings[symbol] = rates
+	}
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].FundingTime > t.UnixMilli() })
+	if i == 0 {
+		return e.fundingRate
+	}
+	rate, err := strconv.ParseFloat(rates[i-1].FundingRate, 64)
+	if err != nil {
+		return e.fundingRate
+	}
+	return rate
+}
+
+// K线撮合 阳线按 开-低-高-收 阴线按 开-高-低-收 的路径分段撮合
+// 成交量按各段价格长度分配 同一根K线里先到达的价位先成交
+func (e *simExchange) matchKline(symbol string, kline *futures.Kline) {
+	open, _ := strconv.ParseFloat(kline.Open, 64)
+	high, _ := strconv.ParseFloat(kline.High, 64)
+	low, _ := strconv.ParseFloat(kline.Low, 64)
+	closePrice, _ := strconv.ParseFloat(kline.Close, 64)
+	volume, _ := strconv.ParseFloat(kline.Volume, 64)
+	// 在这根K线开盘之后才下单或改价的 看不到下单之前的高低点 只按收盘价撮合
+	var late, early []*simOrder
+	for _, o := range e.orders {
+		if o.order.Symbol == symbol && o.placed > kline.OpenTime {
+			late = append(late, o)
+		} else {
+			early = append(early, o)
+		}
+	}
+	e.orders = early
+	e.matchKlinePath(symbol, open, high, low, closePrice, volume)
+	if len(late) > 0 {
+		early = e.orders
+		e.orders = late
+		e.matchPath(symbol, closePrice, closePrice, 0)
+		e.orders = append(early, e.orders...)
+	}
+}
+
+func (e *simExchange) matchKlinePath(symbol string, open, high, low, closePrice, volume float64) {
+	path := []float64{open, low, high, closePrice}
+	if closePrice < open {
+		path = []float64{open, high, low, closePrice}
+	}
+	var length float64
+	for i := 1; i < len(path); i++ {
+		length += math.Abs(path[i] - path[i-1])
+	}
+	if length == 0 {
+		e.matchPath(symbol, open, open, volume)
+		return
+	}
+	for i := 1; i < len(path); i++ {
+		if path[i] == path[i-1] {
+			continue
+		}
+		e.matchPath(symbol, path[i-1], path[i], volume*math.Abs(path[i]-path[i-1])/length)
+	}
+}
+
+// 撮合一段价格从 from 单向走到 to 的行情 成交量 volume 均匀分布在各价位
+// 按价格到达的先后处理订单 价格穿过挂单价时全部成交，只触及时先消耗排在前面的数量
+func (e *simExchange) matchPath(symbol string, from, to, volume float64) {
+	low, high := math.Min(from, to), math.Max(from, to)
+	tick := e.tickSize(symbol)
+	levelVolume := volume / math.Max(1, (high-low)/tick+1)
+
+	matching := make([]*simOrder, 0)
+	remaining := e.orders[:0]
+	for _, o := range e.orders {
+		if o.order.Symbol != symbol {
+			remaining = append(remaining, o)
+			continue
+		}
+		matching = append(matching, o)
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return math.Abs(e.reachPrice(matching[i], low, high, to)-from) < math.Abs(e.reachPrice(matching[j], low, high, to)-from)
+	})
+	for _, o := range matching {
+		if !e.matchOrder(o, low, high, levelVolume, tick) {
+			remaining = append(remaining, o)
+		}
+	}
+	e.orders = remaining
+}
+
+// 订单在这段行情里被触及的价格 用于排序 跟踪止损放在最后
+func (e *simExchange) reachPrice(o *simOrder, low, high, to float64) float64 {
+	price := o.price
+	switch {
+	case isStopOrder(o.order.Type):
+		price = o.stop
+	case o.order.Type == futures.OrderTypeTrailingStopMarket:
+		return to
+	}
+	return math.Min(math.Max(price, low), high)
+}
+
+// 在 [low, high] 区间内撮合单个订单 返回订单是否结束
+func (e *simExchange) matchOrder(o *simOrder, low, high, levelVolume, tick float64) bool {
+	if isStopOrder(o.order.Type) {
+		return e.triggerStop(o, low, high)
+	}
+	if o.order.Type == futures.OrderTypeTrailingStopMarket {
+		return e.trailStop(o, low, high)
+	}
+	var through, touch bool
+	if o.order.Side == futures.SideTypeBuy {
+		through = low < o.price-tick/2
+		touch = !through && low <= o.price+tick/2
+	} else {
+		through = high > o.price+tick/2
+		touch = !through && high >= o.price-tick/2
+	}
+	switch {
+	case through:
+		e.fill(o, o.price, o.remaining(), true)
+	case touch:
+		o.queue -= levelVolume
+		if o.queue < 0 {
+			e.fill(o, o.price, math.Min(o.remaining(), -o.queue), true)
+			o.queue = 0
+		}
+	}
+	return o.remaining() <= simEpsilon
+}
+
+// 条件单类型
+func isStopOrder(orderType futures.OrderType) bool {
+	return orderType == futures.OrderTypeStopMarket || orderType == futures.OrderTypeTakeProfitMarket
+}
+
+// 价格是否到达触发价 买入止损与卖出止盈向上触发 其余向下
+func stopTriggered(o *simOrder, low, high float64) bool {
+	up := (o.order.Type == futures.OrderTypeStopMarket) == (o.order.Side == futures.SideTypeBuy)
+	if up {
+		return high >= o.stop
+	}
+	return low <= o.stop
+}
+
+// 条件单触发后按市价成交 价格跳空时按区间内最接近触发价的价格
+// closePosition 以触发时的持仓数量平仓 没有持仓时失效
+func (e *simExchange) triggerStop(o *simOrder, low, high float64) bool {
+	if !stopTriggered(o, low, high) {
+		return false
+	}
+	return e.triggerMarket(o, math.Min(math.Max(o.stop, low), high))
+}
+
+// 跟踪止损 激活后价格从最优价回调 callback 比例时触发
+// 先以之前的最优价判断触发 再用本段价格更新最优价 偏保守
+func (e *simExchange) trailStop(o *simOrder, low, high float64) bool {
+	sell := o.order.Side == futures.SideTypeSell
+	if o.activated {
+		if sell && low <= o.extreme*(1-o.callback) {
+			return e.triggerMarket(o, math.Min(o.extreme*(1-o.callback), high))
+		}
+		if !sell && high >= o.extreme*(1+o.callback) {
+			return e.triggerMarket(o, math.Max(o.extreme*(1+o.callback), low))
+		}
+	}
+	favorable := low
+	if sell {
+		favorable = high
+	}
+	switch {
+	case !o.activated:
+		if o.activation == 0 || (sell && high >= o.activation) || (!sell && low <= o.activation) {
+			o.activated = true
+			o.extreme = favorable
+		}
+	case sell:
+		o.extreme = math.Max(o.extreme, high)
+	default:
+		o.extreme = math.Min(o.extreme, low)
+	}
+	return false
+}
+
+// 条件单触发后以 price 市价成交 返回订单是否结束
+func (e *simExchange) triggerMarket(o *simOrder, price float64) bool {
+	quantity := o.remaining()
+	if !isOpening(o.order.Side, o.order.PositionSide) {
+		var amt float64
+		if p := e.positions[simKey{o.order.Symbol, o.order.PositionSide}]; p != nil {
+			amt = math.Abs(p.Amt)
+		}
+		if o.order.ClosePosition || quantity > amt {
+			quantity = amt
+		}
+	}
+	if quantity < simEpsilon {
+		o.order.Status = futures.OrderStatusTypeExpired
+		o.order.UpdateTime = e.now.UnixMilli()
+		return true
+	}
+	o.quantity = o.executed + quantity
+	e.fill(o, price, quantity, false)
+	return true
+}
+
+// 成交 更新订单、持仓与余额
+func (e *simExchange) fill(o *simOrder, price, quantity float64, maker bool) {
+	key := simKey{o.order.Symbol, o.order.PositionSide}
+	position, ok := e.positions[key]
+	if !ok {
+		position = &simPosition{}
+		e.positions[key] = position
+	}
+	o.executed += quantity
+	o.order.ExecutedQuantity = formatFloat(o.executed)
+	o.order.AvgPrice = formatFloat(price)
+	o.order.UpdateTime = e.now.UnixMilli()
+	if o.remaining() > simEpsilon {
+		o.order.Status = futures.OrderStatusTypePartiallyFilled
+	} else {
+		o.order.Status = futures.OrderStatusTypeFilled
+	}
+
+	rate := e.takerFee
+	if maker {
+		rate = e.makerFee
+	}
+	fee := price * quantity * rate
+	e.balance -= fee
+
+	var realized float64
+	if isOpening(o.order.Side, o.order.PositionSide) {
+		if position.Amt == 0 {
+			position.Tag = o.tag
+			position.Open = e.now
+			position.PnL = 0
+		}
+		position.Entry = (position.Entry*math.Abs(position.Amt) + price*quantity) / (math.Abs(position.Amt) + quantity)
+		position.Amt += sideSign(o.order.PositionSide) * quantity
+		position.PnL -= fee
+	} else {
+		realized = (price - position.Entry) * quantity * sideSign(o.order.PositionSide)
+		position.Amt -= sideSign(o.order.PositionSide) * quantity
+		position.PnL += realized - fee
+		e.balance += realized
+		if math.Abs(position.Amt) < simEpsilon {
+			e.trips = append(e.trips, simRoundTrip{
+				Symbol:       o.order.Symbol,
+				PositionSide: o.order.PositionSide,
+				Tag:          position.Tag,
+				Open:         position.Open,
+				Close:        e.now,
+				PnL:          position.PnL,
+			})
+			position.Amt = 0
+			position.Entry = 0
+		}
+	}
+	e.fills = append(e.fills, simFill{
+		Time:         e.now,
+		OrderID:      o.order.OrderID,
+		Symbol:       o.order.Symbol,
+		Side:         o.order.Side,
+		PositionSide: o.order.PositionSide,
+		Price:        price,
+		Quantity:     quantity,
+		Realized:     realized,
+		Fee:          fee,
+		Maker:        maker,
+		Tag:          position.Tag,
+	})
+}
+
+// 双向持仓下 买多卖空为开仓
+func isOpening(side futures.SideType, positionSide futures.PositionSideType) bool {
+	return (positionSide == futures.PositionSideTypeLong) == (side == futures.SideTypeBuy)
+}
+
+// 多为 1 空为 -1
+func sideSign(positionSide futures.PositionSideType) float64 {
+	if positionSide == futures.PositionSideTypeShort {
+		return -1
+	}
+	return 1
+}
+
+// 为本轮新挂单打上信号类型
+func (e *simExchange) TagOrders(afterID int64, tags map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, o := range e.orders {
+		if o.order.OrderID > afterID {
+			o.tag = tags[o.order.Symbol]
+		}
+	}
+}
+
+// 最后一个订单号
+func (e *simExchange) LastOrderID() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.nextID - 1
+}
+
+// 有挂单或持仓的合约
+func (e *simExchange) ActiveSymbols() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	seen := e.activeSet()
+	symbols := make([]string, 0, len(seen))
+	for symbol := range seen {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// 有挂单或持仓的合约
+func (e *simExchange) activeSet() map[string]bool {
+	active := make(map[string]bool)
+	for _, o := range e.orders {
+		active[o.order.Symbol] = true
+	}
+	for key, p := range e.positions {
+		if p.Amt != 0 {
+			active[key.Symbol] = true
+		}
+	}
+	return active
+}
+
+// 权益 余额加未实现盈亏
+func (e *simExchange) Equity() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	equity := e.balance
+	for key, p := range e.positions {
+		if p.Amt == 0 {
+			continue
+		}
+		if price, err := e.lastPrice(key.Symbol); err == nil {
+			equity += (price - p.Entry) * p.Amt
+		}
+	}
+	return equity
+}
+
+// 保证金占用
+func (e *simExchange) margins() (positionMargin, orderMargin, unrealized float64) {
+	for key, p := range e.positions {
+		if p.Amt == 0 {
+			continue
+		}
+		if price, err := e.lastPrice(key.Symbol); err == nil {
+			unrealized += (price - p.Entry) * p.Amt
+			positionMargin += math.Abs(p.Amt) * price / e.leverage
+		}
+	}
+	for _, o := range e.orders {
+		if isOpening(o.order.Side, o.order.PositionSide) {
+			orderMargin += o.price * o.remaining() / e.leverage
+		}
+	}
+	return positionMargin, orderMargin, unrealized
+}
+
+// 更新外部深度 之后的挂单以它判断买一卖一与排队数量
+func (e *simExchange) UpdateBook(symbol string, book *futures.DepthResponse) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.books[symbol] = book
+}
+
+// 去掉没有挂单和持仓的合约的外部深度 它们不再刷新 留着会过期
+func (e *simExchange) PruneBooks() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	active := e.activeSet()
+	for symbol := range e.books {
+		if !active[symbol] {
+			delete(e.books, symbol)
+		}
+	}
+}
+
+// 外部深度中 price 价位的挂单量
+func (e *simExchange) bookQty(symbol string, side futures.SideType, price float64) (float64, bool) {
+	book, ok := e.books[symbol]
+	if !ok {
+		return 0, false
+	}
+	levels := book.Bids
+	if side == futures.SideTypeSell {
+		levels = book.Asks
+	}
+	for _, level := range levels {
+		p, _ := strconv.ParseFloat(level.Price, 64)
+		if math.Abs(p-price) < simEpsilon {
+			qty, _ := strconv.ParseFloat(level.Quantity, 64)
+			return qty, true
+		}
+	}
+	return 0, true
+}
+
+// 买一卖一
+func (e *simExchange) bestBidAsk(symbol string) (bid, ask float64, err error) {
+	if book, ok := e.books[symbol]; ok && len(book.Bids) > 0 && len(book.Asks) > 0 {
+		bid, _ = strconv.ParseFloat(book.Bids[0].Price, 64)
+		ask, _ = strconv.ParseFloat(book.Asks[0].Price, 64)
+		return bid, ask, nil
+	}
+	price, err := e.lastPrice(symbol)
+	if err != nil {
+		return 0, 0, err
+	}
+	tick := e.tickSize(symbol)
+	bid = roundFloat(math.Floor(price/tick+simEpsilon)*tick, 8)
+	return bid, roundFloat(bid+tick, 8), nil
+}
+
+// HTTP 接口
+func (e *simExchange) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		simError(w, -1102, err.Error())
+		return
+	}
+	// ParseForm 不读取 DELETE 的请求体 撤单参数在请求体中
+	if r.Method == http.MethodDelete {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			values, err := url.ParseQuery(string(body))
+			if err == nil {
+				for k, v := range values {
+					r.Form[k] = append(r.Form[k], v...)
+				}
+			}
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var res interface{}
+	var err error
+	switch r.Method + " " + r.URL.Path {
+	case "GET /fapi/v1/ping":
+		res = struct{}{}
+	case "GET /fapi/v1/time":
+		res = map[string]int64{"serverTime": e.now.UnixMilli()}
+	case "GET /fapi/v1/exchangeInfo":
+		res = e.info
+	case "GET /fapi/v1/klines":
+		res, err = e.handleKlines(r)
+	case "GET /fapi/v1/depth":
+		res, err = e.handleDepth(r)
+	case "GET /fapi/v2/account":
+		res = e.handleAccount()
+	case "GET /fapi/v1/openOrders":
+		res = e.handleOpenOrders(r)
+	case "POST /fapi/v1/order":
+		res, err = e.handleCreateOrder(r)
+	case "DELETE /fapi/v1/order":
+		res, err = e.handleCancelOrder(r)
+	case "PUT /fapi/v1/order":
+		res, err = e.handleModifyOrder(r)
+	case "GET /fapi/v1/ticker/24hr":
+		res = e.handleTicker24hr()
+	default:
+		simError(w, -1000, "模拟交易所不支持 "+r.Method+" "+r.URL.Path)
+		return
+	}
+	if err != nil {
+		if apiErr, ok := err.(*simAPIError); ok {
+			simError(w, apiErr.Code, apiErr.Msg)
+			return
+		}
+		simError(w, -1013, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// 带 Binance 错误码的错误
+type simAPIError struct {
+	Code int
+	Msg  string
+}
+
+func (e *simAPIError) Error() string {
+	return fmt.Sprintf("<APIError> code=%d, msg=%s", e.Code, e.Msg)
+}
+
+func simError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "msg": msg})
+}
+
+// 返回截至当前时间的K线 数组格式与 Binance 一致
+func (e *simExchange) handleKlines(r *http.Request) (interface{}, error) {
+	symbol := r.FormValue("symbol")
+	period, err := simKlineInterval(r.FormValue("interval"))
+	if err != nil {
+		return nil, err
+	}
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	if limit <= 0 {
+		limit = 500
+	}
+	klines, err := e.symbolKlines(symbol)
+	if err != nil {
+		return nil, err
+	}
+	now := e.now.UnixMilli()
+	end := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime > now })
+	// 多取一根合成K线的量 保证开头的一根是完整的
+	factor := int(period / simKlinePeriod)
+	start := end - (limit+1)*factor
+	if start < 0 {
+		start = 0
+	}
+	list := klines[start:end]
+	if factor > 1 {
+		list = aggregateKlines(list, period)
+	}
+	if len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	res := make([][]interface{}, 0, len(list))
+	for _, k := range list {
+		res = append(res, []interface{}{k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume, k.CloseTime, k.QuoteAssetVolume, k.TradeNum, k.TakerBuyBaseAssetVolume, k.TakerBuyQuoteAssetVolume, "0"})
+	}
+	return res, nil
+}
+
+// 模拟交易所支持的K线周期 5m 及其整数倍
+func simKlineInterval(interval string) (time.Duration, error) {
+	period, ok := klineIntervalDuration(interval)
+	if !ok || period%simKlinePeriod != 0 {
+		return 0, fmt.Errorf("模拟交易所只支持 5m 及其整数倍的K线: %s", interval)
+	}
+	return period, nil
+}
+
+// 把 5m K线合成为 period 周期 按开盘时间对齐 周线从周一开始
+func aggregateKlines(klines []*futures.Kline, period time.Duration) []*futures.Kline {
+	size := period.Milliseconds()
+	var offset int64
+	if period%(7*24*time.Hour) == 0 {
+		offset = 4 * 24 * time.Hour.Milliseconds() // 1970-01-01 是周四
+	}
+	sum := func(a, b string) string {
+		x, _ := strconv.ParseFloat(a, 64)
+		y, _ := strconv.ParseFloat(b, 64)
+		return formatFloat(x + y)
+	}
+	var res []*futures.Kline
+	var current *futures.Kline
+	for _, k := range klines {
+		openTime := k.OpenTime - ((k.OpenTime-offset)%size+size)%size
+		if current == nil || current.OpenTime != openTime {
+			current = &futures.Kline{
+				OpenTime:                 openTime,
+				Open:                     k.Open,
+				High:                     k.High,
+				Low:                      k.Low,
+				Close:                    k.Close,
+				Volume:                   k.Volume,
+				CloseTime:                openTime + size - 1,
+				QuoteAssetVolume:         k.QuoteAssetVolume,
+				TradeNum:                 k.TradeNum,
+				TakerBuyBaseAssetVolume:  k.TakerBuyBaseAssetVolume,
+				TakerBuyQuoteAssetVolume: k.TakerBuyQuoteAssetVolume,
+			}
+			res = append(res, current)
+			continue
+		}
+		high, _ := strconv.ParseFloat(k.High, 64)
+		low, _ := strconv.ParseFloat(k.Low, 64)
+		if h, _ := strconv.ParseFloat(current.High, 64); high > h {
+			current.High = k.High
+		}
+		if l, _ := strconv.ParseFloat(current.Low, 64); low < l {
+			current.Low = k.Low
+		}
+		current.Close = k.Close
+		current.Volume = sum(current.Volume, k.Volume)
+		current.QuoteAssetVolume = sum(current.QuoteAssetVolume, k.QuoteAssetVolume)
+		current.TradeNum += k.TradeNum
+		current.TakerBuyBaseAssetVolume = sum(current.TakerBuyBaseAssetVolume, k.TakerBuyBaseAssetVolume)
+		current.TakerBuyQuoteAssetVolume = sum(current.TakerBuyQuoteAssetVolume, k.TakerBuyQuoteAssetVolume)
+	}
+	return res
+}
+
+// 以最新价格按最小价格单位生成深度 每档数量为估计的价位挂单量
+func (e *simExchange) handleDepth(r *http.Request) (interface{}, error) {
+	symbol := r.FormValue("symbol")
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	if limit <= 0 {
+		limit = 500
+	}
+	bid, ask, err := e.bestBidAsk(symbol)
+	if err != nil {
+		return nil, err
+	}
+	tick := e.tickSize(symbol)
+	quantity := formatFloat(math.Max(e.levelQty[symbol], 1))
+	bids := make([][]string, 0, limit)
+	asks := make([][]string, 0, limit)
+	for i := 0; i < limit; i++ {
+		bids = append(bids, []string{formatFloat(roundFloat(bid-float64(i)*tick, 8)), quantity})
+		asks = append(asks, []string{formatFloat(roundFloat(ask+float64(i)*tick, 8)), quantity})
+	}
+	return map[string]interface{}{
+		"lastUpdateId": e.now.UnixMilli(),
+		"E":            e.now.UnixMilli(),
+		"T":            e.now.UnixMilli(),
+		"bids":         bids,
+		"asks":         asks,
+	}, nil
+}
+
+func (e *simExchange) tickSize(symbol string) float64 {
+	if s, err := getInfoSymbolsFundData(e.info, symbol); err == nil {
+		for _, f := range s.Filters {
+			if f["filterType"] == "PRICE_FILTER" {
+				if tick, err := strconv.ParseFloat(fmt.Sprint(f["tickSize"]), 64); err == nil && tick > 0 {
+					return tick
+				}
+			}
+		}
+	}
+	return 0.0001
+}
+
+// 去掉浮点误差
+func roundFloat(f float64, decimals int) float64 {
+	scale := math.Pow10(decimals)
+	return math.Round(f*scale) / scale
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// 账户 双向持仓模式 每个合约都返回多空两条持仓
+func (e *simExchange) handleAccount() interface{} {
+	positionMargin, orderMargin, unrealized := e.margins()
+	positions := make([]map[string]interface{}, 0, len(e.info.Symbols)*2)
+	for _, s := range e.info.Symbols {
+		for _, side := range []futures.PositionSideType{futures.PositionSideTypeLong, futures.PositionSideTypeShort} {
+			var amt, entry, profit, notional float64
+			if p := e.positions[simKey{s.Symbol, side}]; p != nil && p.Amt != 0 {
+				amt, entry = p.Amt, p.Entry
+				if price, err := e.lastPrice(s.Symbol); err == nil {
+					profit = (price - entry) * amt
+					notional = price * amt
+				}
+			}
+			positions = append(positions, map[string]interface{}{
+				"symbol":                s.Symbol,
+				"positionSide":          side,
+				"positionAmt":           formatFloat(amt),
+				"entryPrice":            formatFloat(entry),
+				"unrealizedProfit":      formatFloat(profit),
+				"notional":              formatFloat(notional),
+				"positionInitialMargin": formatFloat(math.Abs(notional) / e.leverage),
+				"leverage":              formatFloat(e.leverage),
+				"updateTime":            e.now.UnixMilli(),
+			})
+		}
+	}
+	return map[string]interface{}{
+		"canTrade":                    true,
+		"updateTime":                  e.now.UnixMilli(),
+		"totalWalletBalance":          formatFloat(e.balance),
+		"totalUnrealizedProfit":       formatFloat(unrealized),
+		"totalMarginBalance":          formatFloat(e.balance + unrealized),
+		"totalPositionInitialMargin":  formatFloat(positionMargin),
+		"totalOpenOrderInitialMargin": formatFloat(orderMargin),
+		"totalInitialMargin":          formatFloat(positionMargin + orderMargin),
+		"availableBalance":            formatFloat(e.balance + unrealized - positionMargin - orderMargin),
+		"positions":                   positions,
+	}
+}
+
+func (e *simExchange) handleOpenOrders(r *http.Request) interface{} {
+	symbol := r.FormValue("symbol")
+	res := make([]futures.Order, 0, len(e.orders))
+	for _, o := range e.orders {
+		if symbol == "" || o.order.Symbol == symbol {
+			res = append(res, o.order)
+		}
+	}
+	return res
+}
+
+func (e *simExchange) handleCreateOrder(r *http.Request) (interface{}, error) {
+	symbol := r.FormValue("symbol")
+	if _, err := getInfoSymbolsFundData(e.info, symbol); err != nil {
+		return nil, &simAPIError{-1121, "Invalid symbol."}
+	}
+	closePosition := r.FormValue("closePosition") == "true"
+	reduceOnly := r.FormValue("reduceOnly") == "true"
+	var quantity float64
+	if !closePosition {
+		var err error
+		quantity, err = strconv.ParseFloat(r.FormValue("quantity"), 64)
+		if err != nil || quantity <= 0 {
+			return nil, &simAPIError{-1102, "Mandatory parameter 'quantity' was not sent, was empty/null, or malformed."}
+		}
+	} else if r.FormValue("quantity") != "" || reduceOnly {
+		return nil, &simAPIError{-1106, "Parameter 'quantity' or 'reduceOnly' sent when not required."}
+	}
+	// 双向持仓模式不接受 reduceOnly
+	if reduceOnly {
+		return nil, &simAPIError{-1106, "Parameter 'reduceOnly' sent when not required."}
+	}
+	o := &simOrder{
+		quantity: quantity,
+		placed:   e.now.UnixMilli(),
+		order: futures.Order{
+			Symbol:           symbol,
+			OrderID:          e.nextID,
+			ClientOrderID:    r.FormValue("newClientOrderId"),
+			Price:            r.FormValue("price"),
+			OrigQuantity:     r.FormValue("quantity"),
+			ExecutedQuantity: "0",
+			Status:           futures.OrderStatusTypeNew,
+			TimeInForce:      futures.TimeInForceType(r.FormValue("timeInForce")),
+			Type:             futures.OrderType(r.FormValue("type")),
+			OrigType:         futures.OrderType(r.FormValue("type")),
+			Side:             futures.SideType(r.FormValue("side")),
+			PositionSide:     futures.PositionSideType(r.FormValue("positionSide")),
+			StopPrice:        r.FormValue("stopPrice"),
+			ActivatePrice:    r.FormValue("activationPrice"),
+			PriceRate:        r.FormValue("callbackRate"),
+			ClosePosition:    closePosition,
+			WorkingType:      futures.WorkingTypeContractPrice,
+			Time:             e.now.UnixMilli(),
+			UpdateTime:       e.now.UnixMilli(),
+		},
+	}
+	if o.order.PositionSide != futures.PositionSideTypeLong && o.order.PositionSide != futures.PositionSideTypeShort {
+		return nil, &simAPIError{-4061, "Order's position side does not match user's setting."}
+	}
+	opening := isOpening(o.order.Side, o.order.PositionSide)
+	if closePosition && (opening || !isStopOrder(o.order.Type)) {
+		return nil, &simAPIError{-4136, "Target strategy invalid for orderType " + string(o.order.Type) + ",closePosition true"}
+	}
+	if !opening && !closePosition {
+		// 平仓数量不能超过持仓
+		p := e.positions[simKey{symbol, o.order.PositionSide}]
+		if p == nil || math.Abs(p.Amt) < quantity-simEpsilon {
+			return nil, &simAPIError{-2022, "ReduceOnly Order is rejected."}
+		}
+	}
+	bid, ask, err := e.bestBidAsk(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.order.Type {
+	case futures.OrderTypeLimit:
+		o.price, err = strconv.ParseFloat(o.order.Price, 64)
+		if err != nil || o.price <= 0 {
+			return nil, &simAPIError{-1102, "Mandatory parameter 'price' was not sent, was empty/null, or malformed."}
+		}
+		marketable := (o.order.Side == futures.SideTypeBuy && o.price >= ask) || (o.order.Side == futures.SideTypeSell && o.price <= bid)
+		if marketable && o.order.TimeInForce == futures.TimeInForceTypeGTX {
+			return nil, &simAPIError{-5022, "Due to the order could not be executed as maker, the Post Only order will be rejected."}
+		}
+		if opening && !e.marginEnough(o.price*quantity) {
+			return nil, &simAPIError{-2019, "Margin is insufficient."}
+		}
+		e.nextID++
+		if marketable {
+			e.fill(o, e.takerPrice(o.order.Side, bid, ask), quantity, false)
+			return o.order, nil
+		}
+		e.queueOrder(o, bid, ask)
+		e.orders = append(e.orders, o)
+	case futures.OrderTypeMarket:
+		price := e.takerPrice(o.order.Side, bid, ask)
+		if opening && !e.marginEnough(price*quantity) {
+			return nil, &simAPIError{-2019, "Margin is insufficient."}
+		}
+		e.nextID++
+		e.fill(o, price, quantity, false)
+	case futures.OrderTypeStopMarket, futures.OrderTypeTakeProfitMarket:
+		o.stop, err = strconv.ParseFloat(o.order.StopPrice, 64)
+		if err != nil || o.stop <= 0 {
+			return nil, &simAPIError{-1102, "Mandatory parameter 'stopPrice' was not sent, was empty/null, or malformed."}
+		}
+		price, err := e.lastPrice(symbol)
+		if err != nil {
+			return nil, err
+		}
+		if stopTriggered(o, price, price) {
+			return nil, &simAPIError{-2021, "Order would immediately trigger."}
+		}
+		e.nextID++
+		e.orders = append(e.orders, o)
+	case futures.OrderTypeTrailingStopMarket:
+		rate, err := strconv.ParseFloat(o.order.PriceRate, 64)
+		if err != nil || rate < 0.1 || rate > 5 {
+			return nil, &simAPIError{-2007, "Invalid callBack rate."}
+		}
+		o.callback = rate / 100
+		price, err := e.lastPrice(symbol)
+		if err != nil {
+			return nil, err
+		}
+		if o.order.ActivatePrice != "" {
+			o.activation, err = strconv.ParseFloat(o.order.ActivatePrice, 64)
+			if err != nil {
+				return nil, &simAPIError{-1102, "Parameter 'activationPrice' was malformed."}
+			}
+			if (o.order.Side == futures.SideTypeSell && o.activation <= price) || (o.order.Side == futures.SideTypeBuy && o.activation >= price) {
+				return nil, &simAPIError{-2021, "Order would immediately trigger."}
+			}
+		} else {
+			o.activated = true
+			o.extreme = price
+		}
+		e.nextID++
+		e.orders = append(e.orders, o)
+	default:
+		return nil, &simAPIError{-1116, "Invalid orderType."}
+	}
+	return o.order, nil
+}
+
+// 挂在已有价位上时排在该价位已有挂单之后
+func (e *simExchange) queueOrder(o *simOrder, bid, ask float64) {
+	o.queue = 0
+	if qty, ok := e.bookQty(o.order.Symbol, o.order.Side, o.price); ok {
+		o.queue = qty
+	} else if (o.order.Side == futures.SideTypeBuy && o.price <= bid) || (o.order.Side == futures.SideTypeSell && o.price >= ask) {
+		o.queue = e.levelQty[o.order.Symbol]
+	}
+}
+
+// 吃单价格
+func (e *simExchange) takerPrice(side futures.SideType, bid, ask float64) float64 {
+	if side == futures.SideTypeBuy {
+		return ask
+	}
+	return bid
+}
+
+// 可用保证金是否足够
+func (e *simExchange) marginEnough(notional float64) bool {
+	positionMargin, orderMargin, unrealized := e.margins()
+	available := e.balance + unrealized - positionMargin - orderMargin
+	return notional/e.leverage <= available
+}
+
+func (e *simExchange) handleCancelOrder(r *http.Request) (interface{}, error) {
+	orderID, _ := strconv.ParseInt(r.FormValue("orderId"), 10, 64)
+	for i, o := range e.orders {
+		if o.order.OrderID == orderID && o.order.Symbol == r.FormValue("symbol") {
+			e.orders = append(e.orders[:i], e.orders[i+1:]...)
+			o.order.Status = futures.OrderStatusTypeCanceled
+			o.order.UpdateTime = e.now.UnixMilli()
+			return o.order, nil
+		}
+	}
+	return nil, &simAPIError{-2011, "Unknown order sent."}
+}
+
+// 修改限价单的价格和数量 改价或加量后重新排队
+func (e *simExchange) handleModifyOrder(r *http.Request) (interface{}, error) {
+	orderID, _ := strconv.ParseInt(r.FormValue("orderId"), 10, 64)
+	var o *simOrder
+	index := -1
+	for i, order := range e.orders {
+		if order.order.OrderID == orderID && order.order.Symbol == r.FormValue("symbol") {
+			o, index = order, i
+			break
+		}
+	}
+	if o == nil {
+		return nil, &simAPIError{-2013, "Order does not exist."}
+	}
+	if o.order.Type != futures.OrderTypeLimit {
+		return nil, &simAPIError{-4186, "Only limit order can be modified."}
+	}
+	if futures.SideType(r.FormValue("side")) != o.order.Side {
+		return nil, &simAPIError{-1102, "Mandatory parameter 'side' was not sent, was empty/null, or malformed."}
+	}
+	quantity, err := strconv.ParseFloat(r.FormValue("quantity"), 64)
+	if err != nil || quantity <= 0 {
+		return nil, &simAPIError{-1102, "Mandatory parameter 'quantity' was not sent, was empty/null, or malformed."}
+	}
+	price, err := strconv.ParseFloat(r.FormValue("price"), 64)
+	if err != nil || price <= 0 {
+		return nil, &simAPIError{-1102, "Mandatory parameter 'price' was not sent, was empty/null, or malformed."}
+	}
+	if quantity < o.executed+simEpsilon {
+		return nil, &simAPIError{-4003, "Quantity less than or equal to executed quantity."}
+	}
+	if price == o.price && quantity == o.quantity {
+		return nil, &simAPIError{-5027, "No need to modify the order."}
+	}
+	bid, ask, err := e.bestBidAsk(o.order.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	marketable := (o.order.Side == futures.SideTypeBuy && price >= ask) || (o.order.Side == futures.SideTypeSell && price <= bid)
+	if marketable && o.order.TimeInForce == futures.TimeInForceTypeGTX {
+		return nil, &simAPIError{-5022, "Due to the order could not be executed as maker, the Post Only order will be rejected."}
+	}
+	opening := isOpening(o.order.Side, o.order.PositionSide)
+	if opening && price*quantity > o.price*o.quantity && !e.marginEnough(price*quantity-o.price*o.quantity) {
+		return nil, &simAPIError{-2019, "Margin is insufficient."}
+	}
+	requeue := price != o.price || quantity > o.quantity
+	o.price = price
+	o.quantity = quantity
+	o.order.Price = r.FormValue("price")
+	o.order.OrigQuantity = r.FormValue("quantity")
+	o.order.UpdateTime = e.now.UnixMilli()
+	if marketable {
+		e.fill(o, e.takerPrice(o.order.Side, bid, ask), o.remaining(), false)
+		e.orders = append(e.orders[:index], e.orders[index+1:]...)
+		return o.order, nil
+	}
+	if requeue {
+		o.placed = e.now.UnixMilli()
+		e.queueOrder(o, bid, ask)
+	}
+	return o.order, nil
+}
+
+// 24小时成交额 只统计已加载K线的合约
+func (e *simExchange) handleTicker24hr() interface{} {
+	res := make([]futures.PriceChangeStats, 0, len(e.klines))
+	from := e.now.Add(-24 * time.Hour).UnixMilli()
+	for symbol, klines := range e.klines {
+		var volume float64
+		for _, k := range klines[:e.cursor[symbol]] {
+			if k.OpenTime >= from {
+				v, _ := strconv.ParseFloat(k.QuoteAssetVolume, 64)
+				volume += v
+			}
+		}
+		res = append(res, futures.PriceChangeStats{Symbol: symbol, QuoteVolume: formatFloat(volume)})
+	}
+	return res
+}
+
+// 可持久化的模拟账户状态
+type simState struct {
+	Time      time.Time          `json:"time"`
+	Balance   float64            `json:"balance"`
+	Funding   float64            `json:"funding"`
+	NextID    int64              `json:"nextId"`
+	Prices    map[string]float64 `json:"prices"`
+	Orders    []simOrderState    `json:"orders"`
+	Positions []simPositionState `json:"positions"`
+	Fills     []simFill          `json:"fills"`
+	Trips     []simRoundTrip     `json:"trips"`
+}
+
+type simOrderState struct {
+	Order    futures.Order `json:"order"`
+	Price    float64       `json:"price"`
+	Quantity float64       `json:"quantity"`
+	Executed float64       `json:"executed"`
+	Queue    float64       `json:"queue"`
+	Tag      string        `json:"tag"`
+
+	Stop       float64 `json:"stop,omitempty"`
+	Activation float64 `json:"activation,omitempty"`
+	Callback   float64 `json:"callback,omitempty"`
+	Activated  bool    `json:"activated,omitempty"`
+	Extreme    float64 `json:"extreme,omitempty"`
+	Placed     int64   `json:"placed,omitempty"`
+}
+
+type simPositionState struct {
+	Symbol       string                   `json:"symbol"`
+	PositionSide futures.PositionSideType `json:"positionSide"`
+	simPosition
+}
+
+// 导出账户状态
+func (e *simExchange) State() *simState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	state := &simState{
+		Time:    e.now,
+		Balance: e.balance,
+		Funding: e.funding,
+		NextID:  e.nextID,
+		Prices:  make(map[string]float64, len(e.prices)),
+		Fills:   append([]simFill(nil), e.fills...),
+		Trips:   append([]simRoundTrip(nil), e.trips...),
+	}
+	for symbol, price := range e.prices {
+		state.Prices[symbol] = price
+	}
+	for _, o := range e.orders {
+		state.Orders = append(state.Orders, simOrderState{o.order, o.price, o.quantity, o.executed, o.queue, o.tag, o.stop, o.activation, o.callback, o.activated, o.extreme, o.placed})
+	}
+	for key, p := range e.positions {
+		if p.Amt != 0 {
+			state.Positions = append(state.Positions, simPositionState{key.Symbol, key.PositionSide, *p})
+		}
+	}
+	sort.Slice(state.Positions, func(i, j int) bool {
+		return state.Positions[i].Symbol+string(state.Positions[i].PositionSide) < state.Positions[j].Symbol+string(state.Positions[j].PositionSide)
+	})
+	return state
+}
+
+// 恢复账户状态
+func (e *simExchange) Restore(state *simState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.now = state.Time
+	e.balance = state.Balance
+	e.funding = state.Funding
+	e.nextID = state.NextID
+	e.fills = state.Fills
+	e.trips = state.Trips
+	for symbol, price := range state.Prices {
+		e.prices[symbol] = price
+	}
+	e.orders = e.orders[:0]
+	for _, o := range state.Orders {
+		e.orders = append(e.orders, &simOrder{
+			order:      o.Order,
+			price:      o.Price,
+			quantity:   o.Quantity,
+			executed:   o.Executed,
+			queue:      o.Queue,
+			tag:        o.Tag,
+			stop:       o.Stop,
+			activation: o.Activation,
+			callback:   o.Callback,
+			activated:  o.Activated,
+			extreme:    o.Extreme,
+			placed:     o.Placed,
+		})
+	}
+	e.positions = make(map[simKey]*simPosition, len(state.Positions))
+	for _, p := range state.Positions {
+		position := p.simPosition
+		e.positions[simKey{p.Symbol, p.PositionSide}] = &position
+	}
+}
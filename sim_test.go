@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 测试K线起点 资金费时间点 00:00/08:00/16:00 UTC
+var simTestStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// 5分钟K线 开高低收量
+func simTestKline(i int, open, high, low, closePrice, volume float64) *futures.Kline {
+	openTime := simTestStart.Add(time.Duration(i) * 5 * time.Minute).UnixMilli()
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return &futures.Kline{
+		OpenTime:  openTime,
+		CloseTime: openTime + 5*60*1000 - 1,
+		Open:      f(open),
+		High:      f(high),
+		Low:       f(low),
+		Close:     f(closePrice),
+		Volume:    f(volume),
+	}
+}
+
+// 以第一根K线收盘为当前时间的模拟交易所 返回通过 HTTP 访问它的 Exchange
+func newTestSim(t *testing.T, klines ...*futures.Kline) (*simExchange, Exchange) {
+	t.Helper()
+	savedConfig := config
+	t.Cleanup(func() { config = savedConfig })
+	config = Config{}
+	info := &futures.ExchangeInfo{Symbols: []futures.Symbol{{
+		Symbol: "BTCUSDT",
+		Filters: []map[string]interface{}{
+			{"filterType": "PRICE_FILTER", "tickSize": "0.1"},
+			{"filterType": "LOT_SIZE", "stepSize": "0.001"},
+		},
+	}}}
+	sim := newSimExchange(info, 10000, func(symbol string) ([]*futures.Kline, error) {
+		return klines, nil
+	})
+	sim.Advance(simTestStart.Add(5 * time.Minute))
+	if _, err := sim.symbolKlines("BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	client := binance.NewFuturesClient("", "")
+	client.BaseURL = server.URL
+	return sim, newBinanceExchange(client)
+}
+
+func simTestOrder(t *testing.T, ex Exchange, req OrderRequest) {
+	t.Helper()
+	req.Symbol = "BTCUSDT"
+	if _, err := ex.CreateOrder(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSimQueueFill(t *testing.T) {
+	sim, ex := newTestSim(t,
+		simTestKline(0, 100, 100, 100, 100, 10),
+		// 触及 99 但成交量不够排到
+		simTestKline(1, 100, 100, 99, 100, 22),
+		// 成交量足够
+		simTestKline(2, 100, 100, 99, 100, 2200),
+	)
+	// 挂在买一之下 排在该价位估计挂单量 10 之后
+	simTestOrder(t, ex, OrderRequest{Side: futures.SideTypeBuy, PositionSide: futures.PositionSideTypeLong,
+		Type: futures.OrderTypeLimit, TimeInForce: futures.TimeInForceTypeGTC, Quantity: "1", Price: "99"})
+
+	sim.Advance(simTestStart.Add(10 * time.Minute))
+	if len(sim.fills) != 0 {
+		t.Fatalf("filled before queue cleared: %+v", sim.fills)
+	}
+	sim.Advance(simTestStart.Add(15 * time.Minute))
+	if len(sim.fills) != 1 {
+		t.Fatalf("fills = %+v", sim.fills)
+	}
+	fill := sim.fills[0]
+	if !fill.Maker || fill.Price != 99 || fill.Quantity != 1 {
+		t.Errorf("fill = %+v", fill)
+	}
+	if !approx(fill.Fee, 99*simDefaultMakerFee) {
+		t.Errorf("maker fee = %v", fill.Fee)
+	}
+}
+
+func TestSimTakerFee(t *testing.T) {
+	sim, ex := newTestSim(t, simTestKline(0, 100, 100, 100, 100, 10))
+	simTestOrder(t, ex, OrderRequest{Side: futures.SideTypeBuy, PositionSide: futures.PositionSideTypeLong,
+		Type: futures.OrderTypeMarket, Quantity: "2"})
+	if len(sim.fills) != 1 {
+		t.Fatalf("fills = %+v", sim.fills)
+	}
+	// 市价买单按卖一成交
+	fill := sim.fills[0]
+	if fill.Maker || fill.Price != 100.1 {
+		t.Errorf("fill = %+v", fill)
+	}
+	if !approx(fill.Fee, 100.1*2*simDefaultTakerFee) || !approx(sim.balance, 10000-fill.Fee) {
+		t.Errorf("fee = %v balance = %v", fill.Fee, sim.balance)
+	}
+}
+
+func TestSimFunding(t *testing.T) {
+	sim, ex := newTestSim(t, simTestKline(0, 100, 100, 100, 100, 10))
+	simTestOrder(t, ex, OrderRequest{Side: futures.SideTypeBuy, PositionSide: futures.PositionSideTypeLong,
+		Type: futures.OrderTypeMarket, Quantity: "1"})
+	balance := sim.balance
+	// 跨过 08:00 多头支付一次资金费
+	sim.Advance(simTestStart.Add(8*time.Hour + time.Minute))
+	want := 1 * 100 * simDefaultFundingRate
+	if !approx(sim.funding, want) || !approx(sim.balance, balance-want) {
+		t.Errorf("funding = %v balance = %v, want funding %v", sim.funding, sim.balance, want)
+	}
+	// 同一个周期内不重复收取
+	sim.Advance(simTestStart.Add(9 * time.Hour))
+	if !approx(sim.funding, want) {
+		t.Errorf("funding charged twice: %v", sim.funding)
+	}
+}
+
+func TestSimStopAndTakeProfitSameBar(t *testing.T) {
+	tests := []struct {
+		name     string
+		kline    *futures.Kline
+		takeLast bool
+		profit   bool
+	}{
+		// 阴线 开-高-低-收 先到止盈
+		{"down bar", simTestKline(1, 100, 106, 94, 96, 100), false, true},
+		{"down bar reversed orders", simTestKline(1, 100, 106, 94, 96, 100), true, true},
+		// 阳线 开-低-高-收 先到止损
+		{"up bar", simTestKline(1, 100, 106, 94, 104, 100), false, false},
+		{"up bar reversed orders", simTestKline(1, 100, 106, 94, 104, 100), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, ex := newTestSim(t, simTestKline(0, 100, 100, 100, 100, 10), tt.kline)
+			simTestOrder(t, ex, OrderRequest{Side: futures.SideTypeBuy, PositionSide: futures.PositionSideTypeLong,
+				Type: futures.OrderTypeMarket, Quantity: "1"})
+			stop := OrderRequest{Side: futures.SideTypeSell, PositionSide: futures.PositionSideTypeLong,
+				Type: futures.OrderTypeStopMarket, StopPrice: "95", ClosePosition: true}
+			take := OrderRequest{Side: futures.SideTypeSell, PositionSide: futures.PositionSideTypeLong,
+				Type: futures.OrderTypeTakeProfitMarket, StopPrice: "105", ClosePosition: true}
+			if tt.takeLast {
+				simTestOrder(t, ex, stop)
+				simTestOrder(t, ex, take)
+			} else {
+				simTestOrder(t, ex, take)
+				simTestOrder(t, ex, stop)
+			}
+
+			sim.Advance(simTestStart.Add(10 * time.Minute))
+			if len(sim.trips) != 1 {
+				t.Fatalf("trips = %+v", sim.trips)
+			}
+			// 另一条腿因为已经没有持仓而失效
+			if len(sim.orders) != 0 {
+				t.Errorf("orders left = %d", len(sim.orders))
+			}
+			closing := sim.fills[len(sim.fills)-1]
+			wantPrice := 95.0
+			if tt.profit {
+				wantPrice = 105
+			}
+			if closing.Price != wantPrice || (closing.Realized > 0) != tt.profit {
+				t.Errorf("closing fill = %+v, want price %v", closing, wantPrice)
+			}
+		})
+	}
+}
+
+func TestSimPruneBooks(t *testing.T) {
+	sim, ex := newTestSim(t, simTestKline(0, 100, 100, 100, 100, 10))
+	book := &futures.DepthResponse{
+		Bids: []futures.Bid{{Price: "99.5", Quantity: "1"}},
+		Asks: []futures.Ask{{Price: "99.6", Quantity: "1"}},
+	}
+	sim.UpdateBook("BTCUSDT", book)
+	simTestOrder(t, ex, OrderRequest{Side: futures.SideTypeBuy, PositionSide: futures.PositionSideTypeLong,
+		Type: futures.OrderTypeLimit, TimeInForce: futures.TimeInForceTypeGTC, Quantity: "1", Price: "90"})
+	sim.PruneBooks()
+	if _, ok := sim.books["BTCUSDT"]; !ok {
+		t.Fatal("book of a symbol with open orders was pruned")
+	}
+	if err := ex.CancelOrder(context.Background(), "BTCUSDT", sim.LastOrderID()); err != nil {
+		t.Fatal(err)
+	}
+	sim.PruneBooks()
+	if _, ok := sim.books["BTCUSDT"]; ok {
+		t.Fatal("stale book kept after the symbol became inactive")
+	}
+}
+
+func TestSimOrderPlacedMidBar(t *testing.T) {
+	sim, ex := newTestSim(t,
+		simTestKline(0, 100, 100, 100, 100, 10),
+		// 低点可能出现在下单之前
+		simTestKline(1, 100, 100, 98, 100, 2200),
+		simTestKline(2, 100, 100, 98, 100, 2200),
+	)
+	sim.Advance(simTestStart.Add(7 * time.Minute))
+	simTestOrder(t, ex, OrderRequest{Side: futures.SideTypeBuy, PositionSide: futures.PositionSideTypeLong,
+		Type: futures.OrderTypeLimit, TimeInForce: futures.TimeInForceTypeGTC, Quantity: "1", Price: "99"})
+
+	sim.Advance(simTestStart.Add(10 * time.Minute))
+	if len(sim.fills) != 0 {
+		t.Fatalf("filled on a low before the order was placed: %+v", sim.fills)
+	}
+	sim.Advance(simTestStart.Add(15 * time.Minute))
+	if len(sim.fills) != 1 || sim.fills[0].Price != 99 {
+		t.Fatalf("fills = %+v", sim.fills)
+	}
+}
+
+func TestSimAggregatedKlines(t *testing.T) {
+	var klines []*futures.Kline
+	for i := 0; i < 7; i++ {
+		p := 100 + float64(i)
+		klines = append(klines, simTestKline(i, p, p+2, p-1, p+1, 10))
+	}
+	sim, ex := newTestSim(t, klines...)
+	sim.Advance(simTestStart.Add(30 * time.Minute))
+	res, err := ex.Klines(context.Background(), "BTCUSDT", "15m", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("klines = %d", len(res))
+	}
+	k := res[0]
+	want := &futures.Kline{
+		OpenTime:  simTestStart.Add(15 * time.Minute).UnixMilli(),
+		CloseTime: simTestStart.Add(30*time.Minute).UnixMilli() - 1,
+		Open:      "103", High: "107", Low: "102", Close: "106", Volume: "30",
+	}
+	if k.OpenTime != want.OpenTime || k.CloseTime != want.CloseTime || k.Open != want.Open ||
+		k.High != want.High || k.Low != want.Low || k.Close != want.Close || k.Volume != want.Volume {
+		t.Errorf("kline = %+v, want %+v", k, want)
+	}
+	if _, err := ex.Klines(context.Background(), "BTCUSDT", "1m", 2); err == nil {
+		t.Error("1m klines accepted")
+	}
+}
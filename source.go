@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// 资金流数据源 每次调用返回一份快照
+type SignalSource interface {
+	Fetch() ([]FundData, error)
+}
+
+// 当前使用的数据源
+var signalSource SignalSource
+
+// 根据配置创建数据源
+func newSignalSource(name string) (SignalSource, error) {
+	switch name {
+	case "", "coinank":
+		return &coinankSource{}, nil
+	case "file":
+		if config.SourceFile == "" {
+			return nil, fmt.Errorf("source=file 需要配置 sourceFile")
+		}
+		return newFileSource(config.SourceFile)
+	case "binance":
+		// 有录制文件时离线回放
+		if config.AggTradeFile != "" {
+			return newAggTradeFileSource(config.AggTradeFile, time.Duration(config.Duration)*time.Second), nil
+		}
+		source, err := newBinanceFlowSource(config.AggTradeRecordFile)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(symbols))
+		for _, s := range symbols {
+			names = append(names, s.Symbol)
+		}
+		source.Start(names)
+		return source, nil
+	case "replay":
+		return newReplaySource(config.ReplayDir)
+	default:
+		return nil, fmt.Errorf("未知的数据源: %s", name)
+	}
+}
+
+// 数据源是否依赖 Coinank（现货资金流也只来自 Coinank 数据源）
+func sourceNeedsCoinank(name string) bool {
+	return name == "" || name == "coinank"
+}
+
+// Coinank 数据源
+type coinankSource struct{}
+
+func (s *coinankSource) Fetch() ([]FundData, error) {
+	return fetchFundCoinankData(nil)
+}
+
+// 同时把 Coinank 原始响应记入本轮快照
+func (s *coinankSource) FetchRecord(record *cycleRecord) ([]FundData, error) {
+	return fetchFundCoinankData(record)
+}
+
+// 取本轮资金流 数据源支持时记录原始响应
+func fetchSignals(record *cycleRecord) ([]FundData, error) {
+	if source, ok := signalSource.(*coinankSource); ok {
+		return source.FetchRecord(record)
+	}
+	return signalSource.Fetch()
+}
+
+// 文件数据源 每行一份 []FundData 快照（JSON Lines）
+type fileSource struct {
+	mu      sync.Mutex
+	file    *os.File
+	scanner *bufio.Scanner
+}
+
+func newFileSource(path string) (*fileSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &fileSource{file: file, scanner: scanner}, nil
+}
+
+func (s *fileSource) Fetch() ([]FundData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.scanner.Scan() {
+		line := s.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var data []FundData
+		if err := json.Unmarshal(line, &data); err != nil {
+			return nil, fmt.Errorf("解析快照失败: %w", err)
+		}
+		return data, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (s *fileSource) Close() error {
+	return s.file.Close()
+}
+
+// 内存数据源 依次返回预置快照，用于测试
+type memorySource struct {
+	mu        sync.Mutex
+	snapshots [][]FundData
+	next      int
+}
+
+func newMemorySource(snapshots ...[]FundData) *memorySource {
+	return &memorySource{snapshots: snapshots}
+}
+
+func (s *memorySource) Fetch() ([]FundData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next >= len(s.snapshots) {
+		return nil, io.EOF
+	}
+	data := make([]FundData, len(s.snapshots[s.next]))
+	copy(data, s.snapshots[s.next])
+	s.next++
+	return data, nil
+}
+
+// 追加快照
+func (s *memorySource) Push(data []FundData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots = append(s.snapshots, data)
+}
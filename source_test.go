@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 测试用交易所 只实现 K线
+type klineExchange struct {
+	Exchange
+	klines map[string][]*futures.Kline
+}
+
+func (e *klineExchange) Klines(ctx context.Context, symbol, interval string, limit int) ([]*futures.Kline, error) {
+	klines, ok := e.klines[symbol]
+	if !ok {
+		return nil, errors.New("no klines")
+	}
+	return klines, nil
+}
+
+// 按收盘价序列生成K线
+func testKlines(closes func(i int) float64, n int) []*futures.Kline {
+	klines := make([]*futures.Kline, n)
+	for i := range klines {
+		price := strconv.FormatFloat(closes(i), 'f', -1, 64)
+		klines[i] = &futures.Kline{OpenTime: int64(i) * 300000, Open: price, High: price, Low: price, Close: price}
+	}
+	return klines
+}
+
+func TestMemorySourceSignals(t *testing.T) {
+	savedConfig, savedExchange := config, exchange
+	defer func() { config, exchange = savedConfig, savedExchange }()
+	config = Config{
+		MaxCoins:          2,
+		RsiLength:         14,
+		RsiLevel:          20,
+		BuyNetAmount:      1000000,
+		SideNetAmount:     1000000,
+		MultipleNetAmount: 2,
+	}
+	exchange = &klineExchange{klines: map[string][]*futures.Kline{
+		"AAAUSDT": testKlines(func(i int) float64 { return 1 }, 202),
+		"BBBUSDT": testKlines(func(i int) float64 { return 1 }, 202),
+		"CCCUSDT": testKlines(func(i int) float64 { return 1 + float64(i)/100 }, 202),
+	}}
+
+	source := newMemorySource([]FundData{
+		{Coin: "AAA", Side: true, M5Net: 2000000, M15Net: 5000000},
+		{Coin: "BBB", Side: true, M5Net: 100000, M15Net: 100000},
+		{Coin: "CCC", Side: false, M5Net: -2000000, M15Net: -1000000},
+		// 拉不到K线 只跳过该币种
+		{Coin: "DDD", Side: true, M5Net: 1500000, M15Net: 5000000},
+	})
+	data, err := source.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbolsNet, err := getTopAndBottomM5Net(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(symbolsNet) != 4 || symbolsNet[0].Coin != "AAA" || symbolsNet[2].Coin != "CCC" {
+		t.Fatalf("top/bottom = %+v", symbolsNet)
+	}
+	signals, err := filterSymbols(symbolsNet, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"AAA": "VOL", "CCC": "RSI"}
+	if len(signals) != len(want) {
+		t.Fatalf("signals = %+v", signals)
+	}
+	for _, s := range signals {
+		if want[s.Coin] != s.Signal {
+			t.Errorf("%s signal = %q, want %q", s.Coin, s.Signal, want[s.Coin])
+		}
+	}
+
+	// 快照用完后返回 EOF
+	if _, err := source.Fetch(); err != io.EOF {
+		t.Fatalf("Fetch after last snapshot: %v", err)
+	}
+	source.Push([]FundData{{Coin: "AAA"}})
+	if data, err := source.Fetch(); err != nil || len(data) != 1 {
+		t.Fatalf("Fetch after Push = %v, %v", data, err)
+	}
+}
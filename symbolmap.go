@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// Coinank 币种与 Binance 合约的对应关系
+type SymbolMapping struct {
+	Coin       string  `json:"coin"`       // Coinank baseCoin
+	Symbol     string  `json:"symbol"`     // Binance 合约 如 1000PEPEUSDT
+	Multiplier float64 `json:"multiplier"` // 每张合约对应的币数 1000PEPE 为 1000
+}
+
+// 合约名倍数前缀 按长度从长到短匹配
+var symbolMultiplierPrefixes = []struct {
+	prefix     string
+	multiplier float64
+}{
+	{"1000000", 1000000},
+	{"100000", 100000},
+	{"10000", 10000},
+	{"1000", 1000},
+	{"1M", 1000000},
+}
+
+// coin -> 映射
+var symbolMappings = make(map[string]SymbolMapping)
+
+// symbol -> coin
+var symbolCoins = make(map[string]string)
+
+// 按内置规则由 BaseAsset 推出 Coinank 币种和倍数
+func splitMultiplier(baseAsset string) (string, float64) {
+	for _, p := range symbolMultiplierPrefixes {
+		if strings.HasPrefix(baseAsset, p.prefix) && len(baseAsset) > len(p.prefix) {
+			return baseAsset[len(p.prefix):], p.multiplier
+		}
+	}
+	return baseAsset, 1
+}
+
+// 读取用户映射文件 JSON 数组
+func loadSymbolOverrides(path string) ([]SymbolMapping, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides []SymbolMapping
+	if err := json.Unmarshal(b, &overrides); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// 由可交易合约生成映射 用户映射优先
+func buildSymbolMappings(symbols []futures.Symbol, overrides []SymbolMapping) {
+	mappings := make(map[string]SymbolMapping)
+	coins := make(map[string]string)
+	tradable := make(map[string]bool, len(symbols))
+	for _, s := range symbols {
+		tradable[s.Symbol] = true
+		coin, multiplier := splitMultiplier(s.BaseAsset)
+		// 同一币种同时存在 1x 与倍数合约时用 1x
+		if m, ok := mappings[coin]; ok && m.Multiplier <= multiplier {
+			continue
+		}
+		mappings[coin] = SymbolMapping{Coin: coin, Symbol: s.Symbol, Multiplier: multiplier}
+	}
+	for _, o := range overrides {
+		if !tradable[o.Symbol] {
+			log.Println("映射的合约不可交易:", o.Coin, o.Symbol)
+			continue
+		}
+		if o.Multiplier == 0 {
+			o.Multiplier = 1
+		}
+		// 去掉被覆盖合约的原映射
+		for coin, m := range mappings {
+			if m.Symbol == o.Symbol {
+				delete(mappings, coin)
+			}
+		}
+		mappings[o.Coin] = o
+	}
+	for coin, m := range mappings {
+		coins[m.Symbol] = coin
+	}
+	symbolMappings = mappings
+	symbolCoins = coins
+}
+
+// Coinank 币种对应的 Binance 合约
+func binanceSymbol(coin string) string {
+	if m, ok := symbolMappings[coin]; ok {
+		return m.Symbol
+	}
+	return coin + "USDT"
+}
+
+// Binance 合约对应的 Coinank 币种
+func coinOf(symbol string) string {
+	if coin, ok := symbolCoins[symbol]; ok {
+		return coin
+	}
+	return strings.TrimSuffix(symbol, "USDT")
+}
+
+// 合约倍数
+func symbolMultiplier(coin string) float64 {
+	if m, ok := symbolMappings[coin]; ok && m.Multiplier > 0 {
+		return m.Multiplier
+	}
+	return 1
+}
+
+// 币数量转合约数量
+func toContractQuantity(coin string, quantity float64) float64 {
+	return quantity / symbolMultiplier(coin)
+}
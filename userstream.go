@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 用户数据流参数
+const (
+	userStreamKeepalive     = 30 * time.Minute // listenKey 60分钟过期
+	userStreamDefaultResync = 30               // 默认定时 REST 重建间隔 分钟
+	userStreamRetry         = 5 * time.Second
+)
+
+// 用户数据流 实时维护挂单与持仓 挂单和持仓直接从内存返回
+// 连接建立、断线重连和定时都用 REST 快照重建 数据流没有序号 断线即视为有缺口
+type userStreamExchange struct {
+	Exchange
+	client *futures.Client
+
+	mu        sync.RWMutex
+	synced    bool
+	orders    map[int64]*futures.Order
+	done      map[int64]int64                          // 已结束的订单与结束时间 防止迟到的下单响应把它加回来
+	positions map[positionKey]*futures.AccountPosition // 数量为0的保留到下次重建 防止快照把已平的持仓加回来
+	marks     map[string]string                        // 标记价格流 断开时清空
+}
+
+func newUserStreamExchange(live Exchange, client *futures.Client) *userStreamExchange {
+	return &userStreamExchange{
+		Exchange:  live,
+		client:    client,
+		orders:    make(map[int64]*futures.Order),
+		done:      make(map[int64]int64),
+		positions: make(map[positionKey]*futures.AccountPosition),
+		marks:     make(map[string]string),
+	}
+}
+
+// 启动数据流 成交时通知持仓管理
+func (s *userStreamExchange) Start() {
+	go s.run()
+	go s.runMarks()
+}
+
+// 订阅全市场标记价格 持仓的浮盈按它重算 不占 REST 权重
+func (s *userStreamExchange) runMarks() {
+	for {
+		if err := s.serveMarks(); err != nil {
+			log.Println("[MARK]", err)
+		}
+		s.mu.Lock()
+		s.marks = make(map[string]string)
+		s.mu.Unlock()
+		time.Sleep(userStreamRetry)
+	}
+}
+
+func (s *userStreamExchange) serveMarks() error {
+	doneC, _, err := futures.WsAllMarkPriceServe(func(event futures.WsAllMarkPriceEvent) {
+		s.mu.Lock()
+		for _, mark := range event {
+			s.marks[mark.Symbol] = mark.MarkPrice
+		}
+		s.mu.Unlock()
+	}, func(err error) {
+		log.Println("[MARK]", err)
+	})
+	if err != nil {
+		return err
+	}
+	<-doneC
+	return fmt.Errorf("标记价格流断开")
+}
+
+// 连接 断开后重新申请 listenKey 并重建
+func (s *userStreamExchange) run() {
+	for {
+		if err := s.serve(); err != nil {
+			log.Println("[USER]", err)
+		}
+		s.mu.Lock()
+		s.synced = false
+		s.mu.Unlock()
+		time.Sleep(userStreamRetry)
+	}
+}
+
+func (s *userStreamExchange) serve() error {
+	ctx := context.Background()
+	listenKey, err := s.client.NewStartUserStreamService().Do(ctx)
+	if err != nil {
+		return err
+	}
+	expired := make(chan struct{}, 1)
+	doneC, stopC, err := futures.WsUserDataServe(listenKey, func(event *futures.WsUserDataEvent) {
+		if event.Event == futures.UserDataEventTypeListenKeyExpired {
+			select {
+			case expired <- struct{}{}:
+			default:
+			}
+			return
+		}
+		s.handle(event)
+	}, func(err error) {
+		log.Println("[USER]", err)
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		close(stopC)
+		s.client.NewCloseUserStreamService().ListenKey(listenKey).Do(ctx)
+	}()
+	// 先连上再取快照 之间的事件按更新时间合并
+	if err := s.resync(); err != nil {
+		return err
+	}
+	log.Println("[USER] 用户数据流已连接")
+
+	resync := config.UserStreamResync
+	if resync <= 0 {
+		resync = userStreamDefaultResync
+	}
+	keepalive := time.NewTicker(userStreamKeepalive)
+	defer keepalive.Stop()
+	refresh := time.NewTicker(time.Duration(resync) * time.Minute)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-doneC:
+			log.Println("[USER] 断开 重连")
+			return nil
+		case <-expired:
+			log.Println("[USER] listenKey 过期 重连")
+			return nil
+		case <-keepalive.C:
+			if err := s.client.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(ctx); err != nil {
+				return err
+			}
+		case <-refresh.C:
+			if err := s.resync(); err != nil {
+				log.Println("[USER] 重建失败", err)
+			}
+		}
+	}
+}
+
+// 用 REST 快照重建挂单与持仓 快照请求发出后数据流和下单带来的更新保留
+func (s *userStreamExchange) resync() error {
+	ctx := context.Background()
+	start := s.serverTime()
+	orders, err := s.Exchange.OpenOrders(ctx)
+	if err != nil {
+		return err
+	}
+	positions, err := s.Exchange.Positions(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fresh := make(map[int64]*futures.Order, len(orders))
+	for _, order := range orders {
+		// 快照之后数据流已经更新过的以数据流为准
+		if current, ok := s.orders[order.OrderID]; ok && current.UpdateTime > order.UpdateTime {
+			order = current
+		}
+		if _, ok := s.done[order.OrderID]; ok {
+			continue
+		}
+		fresh[order.OrderID] = order
+	}
+	// 快照里没有但在快照开始后才加入的 是快照之后的新挂单
+	for id, order := range s.orders {
+		if _, ok := fresh[id]; !ok && order.UpdateTime >= start {
+			fresh[id] = order
+		}
+	}
+	s.orders = fresh
+	for id, t := range s.done {
+		if t < start {
+			delete(s.done, id)
+		}
+	}
+
+	current := s.positions
+	s.positions = make(map[positionKey]*futures.AccountPosition, len(positions))
+	for _, p := range positions {
+		key := positionKey{p.Symbol, p.PositionSide}
+		// 快照之后数据流已经更新过的以数据流为准
+		if c, ok := current[key]; ok && c.UpdateTime > p.UpdateTime {
+			if amt, err := strconv.ParseFloat(c.PositionAmt, 64); err == nil && amt != 0 {
+				s.positions[key] = c
+			}
+			continue
+		}
+		if amt, err := strconv.ParseFloat(p.PositionAmt, 64); err == nil && amt != 0 {
+			position := *p
+			s.positions[key] = &position
+		}
+	}
+	for key, p := range current {
+		if _, ok := s.positions[key]; !ok && p.UpdateTime >= start {
+			s.positions[key] = p
+		}
+	}
+	s.synced = true
+	return nil
+}
+
+// 按服务器时间的当前毫秒 与快照、数据流和下单响应的 UpdateTime 同一时钟
+func (s *userStreamExchange) serverTime() int64 {
+	now := time.Now().UnixMilli()
+	if s.client != nil {
+		now -= s.client.TimeOffset
+	}
+	return now
+}
+
+func (s *userStreamExchange) handle(event *futures.WsUserDataEvent) {
+	switch event.Event {
+	case futures.UserDataEventTypeOrderTradeUpdate:
+		s.handleOrder(&event.OrderTradeUpdate, event.TransactionTime)
+	case futures.UserDataEventTypeAccountUpdate:
+		s.handleAccount(&event.AccountUpdate, event.TransactionTime)
+	}
+}
+
+// 订单更新 未完成的放入挂单 已完成的移除
+func (s *userStreamExchange) handleOrder(u *futures.WsOrderTradeUpdate, t int64) {
+	if u.ExecutionType == futures.OrderExecutionTypeTrade {
+		log.Println(u.Symbol, u.PositionSide, "[FILL]", u.Side, u.LastFilledQty, "@", u.LastFilledPrice, u.Status)
+		notifyEntryFill()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.orders[u.ID]
+	if ok && current.UpdateTime > t {
+		return
+	}
+	switch u.Status {
+	case futures.OrderStatusTypeNew, futures.OrderStatusTypePartiallyFilled:
+		order := &futures.Order{
+			Symbol:           u.Symbol,
+			OrderID:          u.ID,
+			ClientOrderID:    u.ClientOrderID,
+			Price:            u.OriginalPrice,
+			ReduceOnly:       u.IsReduceOnly,
+			OrigQuantity:     u.OriginalQty,
+			ExecutedQuantity: u.AccumulatedFilledQty,
+			Status:           u.Status,
+			TimeInForce:      u.TimeInForce,
+			Type:             u.Type,
+			Side:             u.Side,
+			StopPrice:        u.StopPrice,
+			Time:             t,
+			UpdateTime:       t,
+			WorkingType:      u.WorkingType,
+			ActivatePrice:    u.ActivationPrice,
+			PriceRate:        u.CallbackRate,
+			AvgPrice:         u.AveragePrice,
+			OrigType:         u.OriginalType,
+			PositionSide:     u.PositionSide,
+			PriceProtect:     u.PriceProtect,
+			ClosePosition:    u.IsClosingPosition,
+		}
+		if ok {
+			order.Time = current.Time
+		}
+		s.orders[u.ID] = order
+	default:
+		delete(s.orders, u.ID)
+		s.done[u.ID] = t
+	}
+}
+
+// 持仓更新 数量变化时通知持仓管理
+func (s *userStreamExchange) handleAccount(u *futures.WsAccountUpdate, t int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, p := range u.Positions {
+		key := positionKey{p.Symbol, p.Side}
+		current, ok := s.positions[key]
+		if ok && current.UpdateTime > t {
+			continue
+		}
+		position := &futures.AccountPosition{
+			Symbol:           p.Symbol,
+			PositionSide:     p.Side,
+			PositionAmt:      p.Amount,
+			EntryPrice:       p.EntryPrice,
+			UnrealizedProfit: p.UnrealizedPnL,
+			UpdateTime:       t,
+		}
+		amt, _ := strconv.ParseFloat(p.Amount, 64)
+		if mark, err := strconv.ParseFloat(p.MarkPrice, 64); err == nil {
+			position.Notional = strconv.FormatFloat(amt*mark, 'f', -1, 64)
+		}
+		s.positions[key] = position
+		if ok && current.PositionAmt == p.Amount || !ok && amt == 0 {
+			continue
+		}
+		changed = true
+		log.Println(p.Symbol, p.Side, "[POSITION]", p.Amount, "均价", p.EntryPrice, u.Reason)
+	}
+	// 持仓数量变化 例如条件单触发平仓
+	if changed {
+		notifyEntryFill()
+	}
+}
+
+// 数据流已同步时从内存返回持仓 浮盈按标记价格流重算 持仓推送只在持仓变化时才有
+func (s *userStreamExchange) Positions(ctx context.Context) ([]*futures.AccountPosition, error) {
+	s.mu.RLock()
+	if !s.synced {
+		s.mu.RUnlock()
+		return s.Exchange.Positions(ctx)
+	}
+	positions := make([]*futures.AccountPosition, 0, len(s.positions))
+	for _, p := range s.positions {
+		amt, err := strconv.ParseFloat(p.PositionAmt, 64)
+		if err != nil || amt == 0 {
+			continue
+		}
+		position := *p
+		mark, err1 := strconv.ParseFloat(s.marks[p.Symbol], 64)
+		entry, err2 := strconv.ParseFloat(p.EntryPrice, 64)
+		if err1 == nil && err2 == nil {
+			position.Notional = strconv.FormatFloat(amt*mark, 'f', -1, 64)
+			position.UnrealizedProfit = strconv.FormatFloat(amt*(mark-entry), 'f', -1, 64)
+		}
+		positions = append(positions, &position)
+	}
+	s.mu.RUnlock()
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Symbol != positions[j].Symbol {
+			return positions[i].Symbol < positions[j].Symbol
+		}
+		return positions[i].PositionSide < positions[j].PositionSide
+	})
+	return positions, nil
+}
+
+// 直接从 REST 取持仓 下单后马上确认时数据流可能还没推送
+func (s *userStreamExchange) RESTPositions(ctx context.Context) ([]*futures.AccountPosition, error) {
+	return s.Exchange.Positions(ctx)
+}
+
+// 数据流已同步时从内存返回挂单
+func (s *userStreamExchange) OpenOrders(ctx context.Context) ([]*futures.Order, error) {
+	s.mu.RLock()
+	if s.synced {
+		orders := make([]*futures.Order, 0, len(s.orders))
+		for _, order := range s.orders {
+			o := *order
+			orders = append(orders, &o)
+		}
+		s.mu.RUnlock()
+		sort.Slice(orders, func(i, j int) bool { return orders[i].OrderID < orders[j].OrderID })
+		return orders, nil
+	}
+	s.mu.RUnlock()
+	return s.Exchange.OpenOrders(ctx)
+}
+
+// 下单成功后先记入挂单 不等数据流推送
+func (s *userStreamExchange) CreateOrder(ctx context.Context, req OrderRequest) (*futures.CreateOrderResponse, error) {
+	res, err := s.Exchange.CreateOrder(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if res.Status != futures.OrderStatusTypeNew && res.Status != futures.OrderStatusTypePartiallyFilled {
+		return res, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.done[res.OrderID]; ok {
+		return res, nil
+	}
+	if _, ok := s.orders[res.OrderID]; !ok {
+		s.orders[res.OrderID] = &futures.Order{
+			Symbol:           res.Symbol,
+			OrderID:          res.OrderID,
+			ClientOrderID:    res.ClientOrderID,
+			Price:            res.Price,
+			ReduceOnly:       res.ReduceOnly,
+			OrigQuantity:     res.OrigQuantity,
+			ExecutedQuantity: res.ExecutedQuantity,
+			Status:           res.Status,
+			TimeInForce:      res.TimeInForce,
+			Type:             res.Type,
+			Side:             res.Side,
+			StopPrice:        res.StopPrice,
+			Time:             res.UpdateTime,
+			UpdateTime:       res.UpdateTime,
+			WorkingType:      res.WorkingType,
+			ActivatePrice:    res.ActivatePrice,
+			PriceRate:        res.PriceRate,
+			AvgPrice:         res.AvgPrice,
+			OrigType:         res.OrigType,
+			PositionSide:     res.PositionSide,
+			PriceProtect:     res.PriceProtect,
+			ClosePosition:    res.ClosePosition,
+		}
+	}
+	return res, nil
+}
+
+// 撤单成功后立即移出挂单
+func (s *userStreamExchange) CancelOrder(ctx context.Context, symbol string, orderID int64) error {
+	if err := s.Exchange.CancelOrder(ctx, symbol, orderID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.orders, orderID)
+	s.done[orderID] = s.serverTime()
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// 测试用 REST 快照 返回固定的挂单和持仓
+type snapshotExchange struct {
+	Exchange
+	orders    []*futures.Order
+	positions []*futures.AccountPosition
+}
+
+func (e *snapshotExchange) OpenOrders(ctx context.Context) ([]*futures.Order, error) {
+	return e.orders, nil
+}
+
+func (e *snapshotExchange) Positions(ctx context.Context) ([]*futures.AccountPosition, error) {
+	return e.positions, nil
+}
+
+func TestUserStreamResyncKeepsNewerUpdates(t *testing.T) {
+	rest := &snapshotExchange{
+		orders: []*futures.Order{{Symbol: "BTCUSDT", OrderID: 1, UpdateTime: 1000}},
+		positions: []*futures.AccountPosition{
+			{Symbol: "BTCUSDT", PositionSide: futures.PositionSideTypeLong, PositionAmt: "1", EntryPrice: "100", UpdateTime: 1000},
+			{Symbol: "ETHUSDT", PositionSide: futures.PositionSideTypeLong, PositionAmt: "2", EntryPrice: "10", UpdateTime: 1000},
+		},
+	}
+	s := newUserStreamExchange(rest, nil)
+	now := time.Now().UnixMilli()
+	// 快照请求期间下的单和平掉的持仓
+	s.orders[2] = &futures.Order{Symbol: "BTCUSDT", OrderID: 2, UpdateTime: now + 1000}
+	s.orders[3] = &futures.Order{Symbol: "BTCUSDT", OrderID: 3, UpdateTime: 500}
+	s.handleAccount(&futures.WsAccountUpdate{Positions: []futures.WsPosition{
+		{Symbol: "ETHUSDT", Side: futures.PositionSideTypeLong, Amount: "0", EntryPrice: "0"},
+	}}, now+1000)
+	// 快照之后、重建开始之前的加仓
+	s.handleAccount(&futures.WsAccountUpdate{Positions: []futures.WsPosition{
+		{Symbol: "BTCUSDT", Side: futures.PositionSideTypeLong, Amount: "2", EntryPrice: "100"},
+	}}, 1500)
+	if err := s.resync(); err != nil {
+		t.Fatal(err)
+	}
+
+	orders, _ := s.OpenOrders(context.Background())
+	if len(orders) != 2 || orders[0].OrderID != 1 || orders[1].OrderID != 2 {
+		t.Errorf("orders = %+v", orders)
+	}
+	s.marks["BTCUSDT"] = "110"
+	positions, err := s.Positions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positions) != 1 || positions[0].Symbol != "BTCUSDT" {
+		t.Fatalf("positions = %+v", positions)
+	}
+	if positions[0].PositionAmt != "2" {
+		t.Errorf("position = %+v, want the newer stream amount", positions[0])
+	}
+	if mark := positionMarkPrice(positions[0], 2, 100); mark != 110 {
+		t.Errorf("mark = %v", mark)
+	}
+}
+
+func TestRESTPositionsBypassStream(t *testing.T) {
+	savedExchange := exchange
+	defer func() { exchange = savedExchange }()
+	rest := &snapshotExchange{positions: []*futures.AccountPosition{
+		{Symbol: "BTCUSDT", PositionSide: futures.PositionSideTypeLong, PositionAmt: "1", EntryPrice: "100"},
+	}}
+	s := newUserStreamExchange(rest, nil)
+	if err := s.resync(); err != nil {
+		t.Fatal(err)
+	}
+	exchange = s
+	// 市价平仓已成交 数据流还没推送
+	rest.positions = nil
+	if positions, _ := exchange.Positions(context.Background()); len(positions) != 1 {
+		t.Fatalf("cached positions = %+v", positions)
+	}
+	if positions, err := restPositions(context.Background()); err != nil || len(positions) != 0 {
+		t.Fatalf("restPositions = %+v, %v", positions, err)
+	}
+}